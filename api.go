@@ -10,11 +10,15 @@ import (
 	"math"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 	"wechat-hub/auth"
 	"wechat-hub/hub"
+	"wechat-hub/redirect"
 	"wechat-hub/storage"
 )
 
@@ -29,25 +33,69 @@ type (
 		sender           *MsgSender
 		auth             *auth.Manager
 		member           hub.MemberManager
+		message          hub.MessageManager
+		outbox           hub.OutboxManager
+		audit            hub.AuditManager
+		replayer         Replayer
+		stream           EventStream
 	}
 	HttpHandlerOption = func(sender *HttpHandler)
 
+	// Replayer 历史消息重放
+	Replayer interface {
+		StartReplay(redirector string, client string, q *hub.MessageQuery, max int) (ReplayJob, error)
+		ReplayJob(id int64) (ReplayJob, bool)
+		CancelReplay(id int64) error
+		Clients(redirector string) ([]redirect.ClientInfo, error)
+	}
+
+	// EventStream 实时消息事件流,身份及过滤条件由HttpHandler解析
+	EventStream interface {
+		Stream(w http.ResponseWriter, r *http.Request, identity *auth.Identity, filter hub.SubscribeCommand, lastID int64) error
+	}
+
+	tokenRequest struct {
+		Username  string   `json:"username"` // 令牌所属用户,为空时为当前用户,只有管理员可以为其他用户创建
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		Groups    []string `json:"groups"`    // 可以访问的群,为空时与用户一致
+		ExpiresAt int64    `json:"expiresAt"` // 过期时间,毫秒时间戳,0为永不过期
+	}
+
+	tokenResult struct {
+		*auth.Token
+		Plain string `json:"token"` // 令牌明文,只在创建时返回
+	}
+
+	replayRequest struct {
+		hub.MessageQuery
+		Redirector string `json:"redirector"` // 转发器名称
+		Client     string `json:"client"`     // 客户端id,为空时发送给转发器的所有客户端
+		Max        int    `json:"max"`        // 最多重放的条数,默认及最大为10000
+	}
+
 	httpResult[T any] struct {
 		Code int    `json:"code"` // 0表示成功
 		Msg  string `json:"msg"`  //
 		Data T      `json:"data"`
 	}
+
+	pageResult[T any] struct {
+		Total int64 `json:"total"`
+		Items []T   `json:"items"`
+	}
 )
 
 func WithMaxUploadSize(size int) HttpHandlerOption {
 	return func(handler *HttpHandler) {
-		handler.maxUploadSize = maxUploadSize
+		handler.maxUploadSize = size
 		units := []string{"B", "KB", "MB", "GB"}
 		if size < 1024 {
 			handler.maxUploadSizeStr = fmt.Sprintf("%d%s", size, units[0])
+			return
 		}
 		divisor := math.Log(float64(size)) / math.Log(1024)
-		unitIndex := int(math.Floor(divisor))
+		unitIndex := min(int(math.Floor(divisor)), len(units)-1)
 		value := float64(size) / math.Pow(1024, float64(unitIndex))
 		handler.maxUploadSizeStr = fmt.Sprintf("%.2f%s", value, units[unitIndex])
 	}
@@ -58,6 +106,38 @@ func WithBaseAuth(manager *auth.Manager) HttpHandlerOption {
 	}
 }
 
+func WithMessage(message hub.MessageManager) HttpHandlerOption {
+	return func(handler *HttpHandler) {
+		handler.message = message
+	}
+}
+
+func WithReplayer(replayer Replayer) HttpHandlerOption {
+	return func(handler *HttpHandler) {
+		handler.replayer = replayer
+	}
+}
+
+func WithOutbox(outbox hub.OutboxManager) HttpHandlerOption {
+	return func(handler *HttpHandler) {
+		handler.outbox = outbox
+	}
+}
+
+// WithAuditLog 开启操作审计查询
+func WithAuditLog(audit hub.AuditManager) HttpHandlerOption {
+	return func(handler *HttpHandler) {
+		handler.audit = audit
+	}
+}
+
+// WithEventStream 开启 /msg/stream 事件流接口
+func WithEventStream(stream EventStream) HttpHandlerOption {
+	return func(handler *HttpHandler) {
+		handler.stream = stream
+	}
+}
+
 func NewHttpHandler(storage storage.Storage, member hub.MemberManager, sender *MsgSender, options ...HttpHandlerOption) *HttpHandler {
 	h := &HttpHandler{
 		ServeMux:         http.NewServeMux(),
@@ -75,6 +155,29 @@ func NewHttpHandler(storage storage.Storage, member hub.MemberManager, sender *M
 	h.HandleFunc("/resource", h.resource)
 	h.HandleFunc("/msg/send", h.sendMsg)
 	h.HandleFunc("/group", h.group)
+	if h.message != nil {
+		h.HandleFunc("/msg/list", h.listMsg)
+		h.HandleFunc("/msg/search", h.searchMsg)
+	}
+	if h.stream != nil {
+		h.HandleFunc("/msg/stream", h.streamMsg)
+	}
+	if h.replayer != nil {
+		h.HandleFunc("/msg/replay", h.replayMsg)
+		h.HandleFunc("/redirect/clients", h.redirectClients)
+	}
+	if h.outbox != nil {
+		h.HandleFunc("/outbox", h.listOutbox)
+		h.HandleFunc("/outbox/retry", h.retryOutbox)
+	}
+	if h.audit != nil {
+		h.HandleFunc("/audit", h.listAudit)
+	}
+	if h.auth != nil {
+		h.HandleFunc("/auth/acl", h.acl)
+		h.HandleFunc("/auth/token", h.token)
+		h.HandleFunc("/auth/grant", h.grant)
+	}
 	return h
 }
 
@@ -114,17 +217,57 @@ func (h *HttpHandler) Success(w http.ResponseWriter, data any) {
 	_, _ = w.Write(jsonData)
 }
 
-func (h *HttpHandler) checkAuth(r *http.Request) bool {
-	if h.auth != nil {
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			return false
-		}
-		return h.auth.CheckUser(username, password)
+// identify 识别请求的身份,支持 Bearer 令牌及 basic-auth,未开启认证时返回nil
+func (h *HttpHandler) identify(r *http.Request) (*auth.Identity, error) {
+	if h.auth == nil {
+		return nil, nil
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return h.auth.CheckToken(token)
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errors.New("缺少认证信息")
+	}
+	return h.auth.Authenticate(username, password)
+}
+
+// tokenParam 无法设置请求头的请求通过 token 参数传递令牌,已有 Authorization 请求头时忽略
+func (h *HttpHandler) tokenParam(r *http.Request) {
+	if token := r.URL.Query().Get("token"); token != "" && h.auth != nil && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// allowGroup 检查身份是否可以访问群,gid为空表示私聊,失败时写入错误响应
+func (h *HttpHandler) allowGroup(w http.ResponseWriter, identity *auth.Identity, gid string) bool {
+	if !identity.AllowGroup(gid) {
+		h.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
 	}
 	return true
 }
 
+// checkAuth 检查请求的身份及权限,失败时写入错误响应
+func (h *HttpHandler) checkAuth(w http.ResponseWriter, r *http.Request, scope string) bool {
+	_, ok := h.authorize(w, r, scope)
+	return ok
+}
+
+// authorize 检查请求的身份及权限并返回身份,失败时写入错误响应
+func (h *HttpHandler) authorize(w http.ResponseWriter, r *http.Request, scope string) (*auth.Identity, bool) {
+	identity, err := h.identify(r)
+	if err != nil {
+		h.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, false
+	}
+	if scope != "" && !identity.Allow(scope) {
+		h.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, false
+	}
+	return identity, true
+}
+
 func (h *HttpHandler) health(w http.ResponseWriter, r *http.Request) {
 	if h.sender.Bot.Alive() {
 		w.WriteHeader(http.StatusOK)
@@ -141,12 +284,11 @@ func (h *HttpHandler) upload(w http.ResponseWriter, r *http.Request) {
 		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
-	if !h.checkAuth(r) {
-		h.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	if !h.checkAuth(w, r, auth.ScopeSend) {
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxUploadSize))
-	err := r.ParseMultipartForm(int64(maxUploadSize))
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.maxUploadSize))
+	err := r.ParseMultipartForm(int64(h.maxUploadSize))
 	if err != nil {
 		slog.Error("HttpHandler upload parse multipart form", "err", err)
 		if errors.Is(err, multipart.ErrMessageTooLarge) {
@@ -194,6 +336,11 @@ func (h *HttpHandler) resource(w http.ResponseWriter, r *http.Request) {
 		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
+	// 资源可能直接用于 <img src>,也可以通过 token 参数传递令牌
+	h.tokenParam(r)
+	if !h.checkAuth(w, r, auth.ScopeReadHistory) {
+		return
+	}
 	resource := r.URL.Query().Get("resource")
 	if resource == "" {
 		h.Error(w, "Invalid resource", http.StatusBadRequest)
@@ -222,8 +369,8 @@ func (h *HttpHandler) sendMsg(w http.ResponseWriter, r *http.Request) {
 		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 		return
 	}
-	if !h.checkAuth(r) {
-		h.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	identity, ok := h.authorize(w, r, auth.ScopeSend)
+	if !ok {
 		return
 	}
 	contentType := r.Header.Get("Content-Type")
@@ -247,6 +394,7 @@ func (h *HttpHandler) sendMsg(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		msg.Gid = r.Form.Get("gid")
+		msg.Uid = r.Form.Get("uid")
 		msg.Body = r.Form.Get("body")
 		msg.Filename = r.Form.Get("filename")
 		msg.Prompt = r.Form.Get("prompt")
@@ -260,7 +408,10 @@ func (h *HttpHandler) sendMsg(w http.ResponseWriter, r *http.Request) {
 		h.Error(w, "Unsupported Content-Type", http.StatusUnsupportedMediaType)
 		return
 	}
-	if err := h.sender.SendMsg(&msg); err != nil {
+	if !h.allowGroup(w, identity, msg.Gid) {
+		return
+	}
+	if _, err := h.sender.SendMsg(&msg, newActor(identity, "HTTP", "")); err != nil {
 		h.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -277,8 +428,8 @@ func (h *HttpHandler) group(w http.ResponseWriter, r *http.Request) {
 		h.Error(w, "Invalid gid", http.StatusBadRequest)
 		return
 	}
-	if !h.checkAuth(r) {
-		h.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	identity, ok := h.authorize(w, r, auth.ScopeReadMembers)
+	if !ok || !h.allowGroup(w, identity, gid) {
 		return
 	}
 
@@ -294,3 +445,494 @@ func (h *HttpHandler) group(w http.ResponseWriter, r *http.Request) {
 	}
 	h.Success(w, users)
 }
+
+// 查询历史消息
+func (h *HttpHandler) listMsg(w http.ResponseWriter, r *http.Request) {
+	h.queryMsg(w, r, false)
+}
+
+// 按关键字搜索历史消息
+func (h *HttpHandler) searchMsg(w http.ResponseWriter, r *http.Request) {
+	h.queryMsg(w, r, true)
+}
+
+func (h *HttpHandler) queryMsg(w http.ResponseWriter, r *http.Request, search bool) {
+	if r.Method != http.MethodGet {
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	identity, ok := h.authorize(w, r, auth.ScopeReadHistory)
+	if !ok {
+		return
+	}
+	query, err := parseMessageQuery(r.URL.Query())
+	if err != nil {
+		h.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search && query.Keyword == "" {
+		h.Error(w, "Invalid keyword", http.StatusBadRequest)
+		return
+	} else if !search {
+		query.Keyword = ""
+	}
+	if err = query.Validate(); err != nil {
+		h.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if identity != nil {
+		query.Gids = identity.Groups
+	}
+	page, err := h.message.Query(query)
+	if err != nil {
+		slog.Error("HttpHandler queryMsg", "err", err)
+		h.Error(w, "Error reading messages from server.", http.StatusInternalServerError)
+		return
+	}
+	h.Success(w, page)
+}
+
+// 以 Server-Sent Events 推送实时消息
+// EventSource 无法设置请求头,开启认证时也可以通过 token 参数传递令牌
+func (h *HttpHandler) streamMsg(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	h.tokenParam(r)
+	identity, ok := h.authorize(w, r, auth.ScopeReadMessages)
+	if !ok {
+		return
+	}
+	filter := hub.SubscribeCommand{
+		Groups: splitQuery(query.Get("groups")),
+		Events: splitQuery(query.Get("events")),
+	}
+	for _, v := range splitQuery(query.Get("types")) {
+		t, err := strconv.Atoi(v)
+		if err != nil {
+			h.Error(w, "Invalid types", http.StatusBadRequest)
+			return
+		}
+		filter.Types = append(filter.Types, t)
+	}
+	for _, gid := range filter.Groups {
+		if !h.allowGroup(w, identity, gid) {
+			return
+		}
+	}
+	// 浏览器重连时通过请求头携带,不支持请求头的客户端可以使用 lastEventId 参数
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(lastEventID, 10, 64); err != nil || lastID < 0 {
+			h.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := h.stream.Stream(w, r, identity, filter, lastID); err != nil {
+		slog.Error("HttpHandler streamMsg", "err", err)
+		h.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// splitQuery 逗号分隔的参数
+func splitQuery(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 重放历史消息到转发器
+func (h *HttpHandler) replayMsg(w http.ResponseWriter, r *http.Request) {
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			h.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		job, ok := h.replayer.ReplayJob(id)
+		if !ok {
+			h.Error(w, "Replay job not found", http.StatusNotFound)
+			return
+		}
+		h.Success(w, job)
+	case http.MethodPost:
+		defer func() {
+			_ = r.Body.Close()
+		}()
+		var req replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("HttpHandler replayMsg decode json", "err", err)
+			h.Error(w, "Error parsing request body.", http.StatusBadRequest)
+			return
+		}
+		if req.Redirector == "" {
+			h.Error(w, "Invalid redirector", http.StatusBadRequest)
+			return
+		}
+		req.Keyword, req.Cursor = "", ""
+		if err := req.Validate(); err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// 在后台执行,通过返回的任务id查询进度
+		job, err := h.replayer.StartReplay(req.Redirector, req.Client, &req.MessageQuery, req.Max)
+		if err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, job)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			h.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		if err = h.replayer.CancelReplay(id); err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, id)
+	default:
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// 查询转发器的在线客户端
+func (h *HttpHandler) redirectClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	clients, err := h.replayer.Clients(r.URL.Query().Get("redirector"))
+	if err != nil {
+		h.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.Success(w, clients)
+}
+
+func parseMessageQuery(values url.Values) (*hub.MessageQuery, error) {
+	query := &hub.MessageQuery{
+		Chat:    values.Get("chatType"),
+		Gid:     values.Get("gid"),
+		Uid:     values.Get("uid"),
+		Keyword: values.Get("keyword"),
+		Cursor:  values.Get("cursor"),
+	}
+	var err error
+	for name, target := range map[string]*int64{"start": &query.Start, "end": &query.End} {
+		if v := values.Get(name); v != "" {
+			if *target, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid %s", name)
+			}
+		}
+	}
+	for name, target := range map[string]*int{"type": &query.Type, "limit": &query.Limit} {
+		if v := values.Get(name); v != "" {
+			if *target, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("Invalid %s", name)
+			}
+		}
+	}
+	return query, nil
+}
+
+// 查询投递箱中待投递或失败的消息
+func (h *HttpHandler) listOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	query := r.URL.Query()
+	status := hub.OutboxPending
+	if s := query.Get("status"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			h.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		status = hub.OutboxStatus(v)
+	}
+	page, size := parsePage(query.Get("page"), query.Get("size"))
+	items, total, err := h.outbox.List(query.Get("redirector"), status, (page-1)*size, size)
+	if err != nil {
+		slog.Error("HttpHandler listOutbox", "err", err)
+		h.Error(w, "Error reading outbox from server.", http.StatusInternalServerError)
+		return
+	}
+	h.Success(w, pageResult[hub.Outbox]{Total: total, Items: items})
+}
+
+// 重新投递失败的消息
+func (h *HttpHandler) retryOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		h.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err = h.outbox.Retry(id); err != nil {
+		h.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.Success(w, "OK")
+}
+
+// 查询发送、撤回消息的操作记录
+func (h *HttpHandler) listAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	query := r.URL.Query()
+	q := &hub.AuditQuery{
+		Username:   query.Get("username"),
+		Channel:    query.Get("channel"),
+		Redirector: query.Get("redirector"),
+		Action:     query.Get("action"),
+		Gid:        query.Get("gid"),
+	}
+	var err error
+	for name, v := range map[string]*int64{"start": &q.Start, "end": &q.End} {
+		if s := query.Get(name); s != "" {
+			if *v, err = strconv.ParseInt(s, 10, 64); err != nil {
+				h.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	page, size := parsePage(query.Get("page"), query.Get("size"))
+	items, total, err := h.audit.List(q, (page-1)*size, size)
+	if err != nil {
+		slog.Error("HttpHandler listAudit", "err", err)
+		h.Error(w, "Error reading audit from server.", http.StatusInternalServerError)
+		return
+	}
+	h.Success(w, pageResult[hub.Audit]{Total: total, Items: items})
+}
+
+// 管理用户的主题权限,需要管理权限
+func (h *HttpHandler) acl(w http.ResponseWriter, r *http.Request) {
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := h.auth.ListACL(r.URL.Query().Get("username"))
+		if err != nil {
+			slog.Error("HttpHandler listACL", "err", err)
+			h.Error(w, "Error reading acl from server.", http.StatusInternalServerError)
+			return
+		}
+		h.Success(w, rules)
+	case http.MethodPost:
+		defer func() {
+			_ = r.Body.Close()
+		}()
+		var req auth.ACL
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("HttpHandler addACL decode json", "err", err)
+			h.Error(w, "Error parsing request body.", http.StatusBadRequest)
+			return
+		}
+		rule, err := h.auth.AddACL(req.Username, req.Topic, req.Read, req.Write)
+		if err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, rule)
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			h.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		if err = h.auth.DeleteACL(id); err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, "OK")
+	default:
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// 管理用户的群授权,需要管理权限
+func (h *HttpHandler) grant(w http.ResponseWriter, r *http.Request) {
+	if !h.checkAuth(w, r, auth.ScopeAdmin) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		grants, err := h.auth.ListGrants(r.URL.Query().Get("username"))
+		if err != nil {
+			slog.Error("HttpHandler listGrants", "err", err)
+			h.Error(w, "Error reading grants from server.", http.StatusInternalServerError)
+			return
+		}
+		h.Success(w, grants)
+	case http.MethodPost:
+		defer func() {
+			_ = r.Body.Close()
+		}()
+		var req auth.Grant
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("HttpHandler addGrant decode json", "err", err)
+			h.Error(w, "Error parsing request body.", http.StatusBadRequest)
+			return
+		}
+		grant, err := h.auth.AddGrant(req.Username, req.Gid)
+		if err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, grant)
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			h.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		if err = h.auth.DeleteGrant(id); err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, "OK")
+	default:
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// 管理API令牌,用户可以管理自己的令牌,管理员可以管理所有令牌
+func (h *HttpHandler) token(w http.ResponseWriter, r *http.Request) {
+	identity, err := h.identify(r)
+	if err != nil {
+		h.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	admin := identity.Allow(auth.ScopeAdmin)
+	switch r.Method {
+	case http.MethodGet:
+		username := r.URL.Query().Get("username")
+		if !admin {
+			username = identity.Username
+		}
+		tokens, err := h.auth.ListTokens(username)
+		if err != nil {
+			slog.Error("HttpHandler listTokens", "err", err)
+			h.Error(w, "Error reading tokens from server.", http.StatusInternalServerError)
+			return
+		}
+		h.Success(w, tokens)
+	case http.MethodPost:
+		defer func() {
+			_ = r.Body.Close()
+		}()
+		var req tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("HttpHandler createToken decode json", "err", err)
+			h.Error(w, "Error parsing request body.", http.StatusBadRequest)
+			return
+		}
+		if req.Username == "" {
+			req.Username = identity.Username
+		} else if req.Username != identity.Username && !admin {
+			h.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		// 不能创建超出自身权限的令牌
+		for _, scope := range req.Scopes {
+			if !identity.Allow(scope) {
+				h.Error(w, "Scope not allowed: "+scope, http.StatusForbidden)
+				return
+			}
+		}
+		if identity.Groups != nil && len(req.Groups) == 0 {
+			req.Groups = identity.Groups
+		}
+		for _, gid := range req.Groups {
+			if !identity.AllowGroup(gid) {
+				h.Error(w, "Group not allowed: "+gid, http.StatusForbidden)
+				return
+			}
+		}
+		// 令牌创建的令牌不能晚于自身过期
+		if identity.ExpiresAt > 0 && (req.ExpiresAt <= 0 || req.ExpiresAt > identity.ExpiresAt) {
+			req.ExpiresAt = identity.ExpiresAt
+		}
+		var expiresAt time.Time
+		if req.ExpiresAt > 0 {
+			expiresAt = time.UnixMilli(req.ExpiresAt)
+		}
+		plain, token, err := h.auth.CreateToken(req.Username, req.Name, req.Scopes, req.Groups, expiresAt)
+		if err != nil {
+			h.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.Success(w, tokenResult{Token: token, Plain: plain})
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			h.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		token, err := h.auth.FindToken(id)
+		if err != nil {
+			h.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if token == nil || (token.Username != identity.Username && !admin) {
+			h.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		if err = h.auth.RevokeToken(id); err != nil {
+			h.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.Success(w, "OK")
+	default:
+		h.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func parsePage(pageStr, sizeStr string) (page int, size int) {
+	page, _ = strconv.Atoi(pageStr)
+	if page < 1 {
+		page = 1
+	}
+	size, _ = strconv.Atoi(sizeStr)
+	if size < 1 || size > 100 {
+		size = 20
+	}
+	return page, size
+}
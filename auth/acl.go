@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// ACL 用户的主题权限,用户没有任何权限规则时不做限制
+type ACL struct {
+	ID       int    `gorm:"primarykey;AUTO_INCREMENT" json:"id"`
+	Username string `gorm:"index;not null" json:"username"`
+	Topic    string `gorm:"not null" json:"topic"` // 主题过滤器,支持+和#通配,如 message/{gid}/#
+	Read     bool   `json:"read"`                  // 允许订阅
+	Write    bool   `json:"write"`                 // 允许发布,如发布命令到 command/{gid}
+}
+
+func (ACL) TableName() string {
+	return "auth_acl"
+}
+
+// AddACL 为用户添加权限规则
+func (m *Manager) AddACL(username string, topic string, read bool, write bool) (*ACL, error) {
+	topic = strings.TrimSpace(topic)
+	if topic == "" {
+		return nil, errors.New("主题不能为空")
+	}
+	if !read && !write {
+		return nil, errors.New("至少需要读或写权限")
+	}
+	if user, err := m.FindUser(username); err != nil {
+		return nil, err
+	} else if user == nil {
+		return nil, errors.New("用户不存在")
+	}
+	acl := &ACL{Username: username, Topic: topic, Read: read, Write: write}
+	if err := m.db.Create(acl).Error; err != nil {
+		return nil, err
+	}
+	m.evictACL(username)
+	return acl, nil
+}
+
+// ListACL 获取权限规则,username为空时返回全部
+func (m *Manager) ListACL(username string) ([]ACL, error) {
+	db := m.db.Order("username").Order("id")
+	if username != "" {
+		db = db.Where("username = ?", username)
+	}
+	var rules []ACL
+	err := db.Find(&rules).Error
+	return rules, err
+}
+
+// DeleteACL 删除权限规则
+func (m *Manager) DeleteACL(id int) error {
+	var acl ACL
+	if err := m.db.Take(&acl, id).Error; err != nil {
+		return err
+	}
+	if err := m.db.Delete(&acl).Error; err != nil {
+		return err
+	}
+	m.evictACL(acl.Username)
+	return nil
+}
+
+// CheckACL 检查用户对主题的读写权限,topic为订阅时可以是带通配符的过滤器,与规则有交集即允许
+func (m *Manager) CheckACL(username string, topic string, write bool) bool {
+	rules, err := m.userACL(username)
+	if err != nil {
+		return false
+	}
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		if (write && !rule.Write) || (!write && !rule.Read) {
+			continue
+		}
+		if topicOverlap(rule.Topic, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) userACL(username string) ([]ACL, error) {
+	m.aclMu.RLock()
+	rules, ok := m.acl[username]
+	m.aclMu.RUnlock()
+	if ok {
+		return rules, nil
+	}
+	rules, err := m.ListACL(username)
+	if err != nil {
+		return nil, err
+	}
+	m.aclMu.Lock()
+	m.acl[username] = rules
+	m.aclMu.Unlock()
+	return rules, nil
+}
+
+func (m *Manager) evictACL(username string) {
+	m.aclMu.Lock()
+	delete(m.acl, username)
+	m.aclMu.Unlock()
+}
+
+// topicOverlap 两个主题过滤器是否可能匹配同一主题
+func topicOverlap(a, b string) bool {
+	x, y := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; ; i++ {
+		if i < len(x) && x[i] == "#" || i < len(y) && y[i] == "#" {
+			return true
+		}
+		if i == len(x) || i == len(y) {
+			return len(x) == len(y)
+		}
+		if x[i] != "+" && y[i] != "+" && x[i] != y[i] {
+			return false
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import "testing"
+
+func TestTopicOverlap(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect bool
+	}{
+		{"message/g1/#", "message/g1/1", true},
+		{"message/g1/#", "message/g1", true},
+		{"message/g1/#", "message/g2/1", false},
+		{"message/+/1", "message/g1/1", true},
+		{"message/+/1", "message/g1/3", false},
+		{"message/g1/1", "message/#", true},
+		{"message/g1/1", "message/+/+", true},
+		{"message/g1", "message/g1/1", false},
+		{"command/g1", "command/g1", true},
+		{"command/g1", "command", false},
+		{"#", "anything/at/all", true},
+	}
+	for _, c := range cases {
+		if overlap := topicOverlap(c.a, c.b); overlap != c.expect {
+			t.Errorf("topicOverlap(%q, %q) = %v, want %v", c.a, c.b, overlap, c.expect)
+		}
+	}
+}
+
+func TestCheckACL(t *testing.T) {
+	m := testManager(t)
+	_ = m.CreateUser("alice", "pw", false)
+	_ = m.CreateUser("free", "pw", false)
+	if _, err := m.AddACL("alice", "message/g1/#", true, false); err != nil {
+		t.Fatal(err)
+	}
+	write, err := m.AddACL("alice", "command/g1", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		username string
+		topic    string
+		write    bool
+		expect   bool
+	}{
+		{"alice", "message/g1/1", false, true},
+		{"alice", "message/#", false, true},
+		{"alice", "message/g2/1", false, false},
+		{"alice", "message/g1/1", true, false},
+		{"alice", "command/g1", true, true},
+		{"alice", "command/g2", true, false},
+		{"alice", "command/g1", false, false},
+		{"free", "command/g2", true, true},
+	}
+	for _, c := range cases {
+		if allow := m.CheckACL(c.username, c.topic, c.write); allow != c.expect {
+			t.Errorf("CheckACL(%q, %q, %v) = %v, want %v", c.username, c.topic, c.write, allow, c.expect)
+		}
+	}
+	// 删除规则后缓存失效
+	if err = m.DeleteACL(write.ID); err != nil {
+		t.Fatal(err)
+	}
+	if m.CheckACL("alice", "command/g1", true) {
+		t.Error("CheckACL() allowed a deleted rule")
+	}
+}
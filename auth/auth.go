@@ -1,20 +1,24 @@
 package auth
 
 import (
-	"crypto/md5"
 	"errors"
-	"fmt"
+	"log/slog"
+	"sync"
+
 	"gorm.io/gorm"
 )
 
 type (
 	Manager struct {
-		db *gorm.DB
+		db    *gorm.DB
+		aclMu sync.RWMutex
+		acl   map[string][]ACL // 用户权限缓存
 	}
 	User struct {
 		ID       int    `gorm:"primarykey;AUTO_INCREMENT"`
 		Username string `gorm:"unique"`
 		Password string `gorm:"not null"`
+		Admin    bool   `gorm:"not null;default:false"` // 管理员,只有管理员的密码认证及令牌拥有admin权限
 	}
 )
 
@@ -22,15 +26,41 @@ func (User) TableName() string {
 	return "auth_user"
 }
 func NewAuthManager(db *gorm.DB) *Manager {
-	if err := db.AutoMigrate(&User{}); err != nil {
+	upgrade := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "Admin")
+	if err := db.AutoMigrate(&User{}, &ACL{}, &Token{}, &Grant{}); err != nil {
 		panic(err)
 	}
-	return &Manager{db: db}
+	if upgrade {
+		// 升级前没有权限规则及群授权的用户拥有管理权限,升级时保留
+		err := db.Model(&User{}).
+			Where("username NOT IN (?)", db.Model(&ACL{}).Select("username")).
+			Where("username NOT IN (?)", db.Model(&Grant{}).Select("username")).
+			Update("admin", true).Error
+		if err != nil {
+			panic(err)
+		}
+	}
+	return &Manager{db: db, acl: map[string][]ACL{}}
+}
+
+func (m *Manager) CreateUser(username, password string, admin bool) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return m.db.Create(&User{Username: username, Password: hash, Admin: admin}).Error
 }
 
-func (m *Manager) CreateUser(username, password string) error {
-	password = fmt.Sprintf("%x", md5.Sum([]byte(password)))
-	return m.db.Create(&User{Username: username, Password: password}).Error
+// SetAdmin 设置用户是否为管理员,用户不存在时返回错误
+func (m *Manager) SetAdmin(username string, admin bool) error {
+	db := m.db.Model(&User{}).Where("username = ?", username).Update("admin", admin)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 func (m *Manager) FindUser(username string) (*User, error) {
@@ -47,9 +77,60 @@ func (m *Manager) CheckUser(username, password string) bool {
 	if err != nil || user == nil {
 		return false
 	}
-	return user.Password == fmt.Sprintf("%x", md5.Sum([]byte(password)))
+	ok, upgrade := verifyPassword(user.Password, password)
+	if ok && upgrade {
+		m.upgradePassword(user, password)
+	}
+	return ok
+}
+
+// upgradePassword 将旧版MD5哈希升级为bcrypt,失败时不影响本次登录
+func (m *Manager) upgradePassword(user *User, password string) {
+	hash, err := hashPassword(password)
+	if err == nil {
+		err = m.db.Model(user).Update("password", hash).Error
+	}
+	if err != nil {
+		slog.Error("升级用户密码哈希失败", "username", user.Username, "err", err)
+		return
+	}
+	slog.Info("已升级用户密码哈希", "username", user.Username)
 }
 
 func (m *Manager) DeleteUser(username string) error {
-	return m.db.Delete(&User{}, "username = ?", username).Error
+	defer m.evictACL(username)
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&ACL{}, "username = ?", username).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Token{}, "username = ?", username).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Grant{}, "username = ?", username).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&User{}, "username = ?", username).Error
+	})
+}
+
+func (m *Manager) ListUsers() ([]User, error) {
+	var users []User
+	err := m.db.Order("id").Find(&users).Error
+	return users, err
+}
+
+// SetPassword 修改用户密码,用户不存在时返回错误
+func (m *Manager) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	db := m.db.Model(&User{}).Where("username = ?", username).Update("password", hash)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
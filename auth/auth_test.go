@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/md5"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	bcryptHash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5Hash := fmt.Sprintf("%x", md5.Sum([]byte("secret")))
+	cases := []struct {
+		hash     string
+		password string
+		ok       bool
+		upgrade  bool
+	}{
+		{bcryptHash, "secret", true, false},
+		{bcryptHash, "wrong", false, false},
+		{md5Hash, "secret", true, true},
+		{md5Hash, "wrong", false, false},
+		{"", "", false, false},
+	}
+	for _, c := range cases {
+		if ok, upgrade := verifyPassword(c.hash, c.password); ok != c.ok || upgrade != c.upgrade {
+			t.Errorf("verifyPassword(%q, %q) = %v, %v, want %v, %v", c.hash, c.password, ok, upgrade, c.ok, c.upgrade)
+		}
+	}
+}
+
+func TestUpgradePassword(t *testing.T) {
+	m := testManager(t)
+	legacy := fmt.Sprintf("%x", md5.Sum([]byte("secret")))
+	if err := m.db.Create(&User{Username: "old", Password: legacy}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !m.CheckUser("old", "secret") {
+		t.Fatal("CheckUser() rejected the legacy password")
+	}
+	user, _ := m.FindUser("old")
+	if !strings.HasPrefix(user.Password, "$2") {
+		t.Fatalf("password hash was not upgraded: %q", user.Password)
+	}
+	if !m.CheckUser("old", "secret") || m.CheckUser("old", "wrong") {
+		t.Fatal("CheckUser() after upgrade")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	m := testManager(t)
+	for _, user := range []struct {
+		name     string
+		password string
+		admin    bool
+	}{
+		{"root", "rootpw", true},
+		{"alice", "alicepw", false},
+		{"bob", TokenPrefix + "not-a-token", false},
+	} {
+		if err := m.CreateUser(user.name, user.password, user.admin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, _, err := m.CreateToken("alice", "t", []string{ScopeSend}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		username string
+		password string
+		ok       bool
+		admin    bool
+		tokenID  bool
+	}{
+		{"root", "rootpw", true, true, false},
+		{"alice", "alicepw", true, false, false},
+		{"alice", "wrong", false, false, false},
+		{"nobody", "alicepw", false, false, false},
+		{"alice", token, true, false, true},
+		{"", token, true, false, true},
+		{"root", token, false, false, false},
+		{"bob", TokenPrefix + "not-a-token", true, false, false},
+		{"alice", TokenPrefix + "not-a-token", false, false, false},
+	}
+	for _, c := range cases {
+		identity, err := m.Authenticate(c.username, c.password)
+		if (err == nil) != c.ok {
+			t.Errorf("Authenticate(%q, %q) err = %v, want ok %v", c.username, c.password, err, c.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if admin := identity.Allow(ScopeAdmin); admin != c.admin {
+			t.Errorf("Authenticate(%q) admin = %v, want %v", c.username, admin, c.admin)
+		}
+		if (identity.TokenID != 0) != c.tokenID {
+			t.Errorf("Authenticate(%q) tokenId = %d", c.username, identity.TokenID)
+		}
+	}
+}
+
+func TestUserIdentity(t *testing.T) {
+	m := testManager(t)
+	_ = m.CreateUser("alice", "pw", false)
+	identity, err := m.Authenticate("alice", "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Groups != nil || slices.Contains(identity.Scopes, ScopeAdmin) {
+		t.Fatalf("identity = %+v", identity)
+	}
+	if _, err = m.AddGrant("alice", "g1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.SetAdmin("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	identity, _ = m.Authenticate("alice", "pw")
+	if !identity.Allow(ScopeAdmin) || !identity.AllowGroup("g1") || identity.AllowGroup("g2") || identity.AllowGroup("") {
+		t.Fatalf("identity = %+v", identity)
+	}
+	if err = m.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Authenticate("alice", "pw"); err == nil {
+		t.Fatal("deleted user authenticated")
+	}
+}
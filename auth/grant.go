@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// Grant 用户可以访问的群,用户没有任何授权时可以访问所有群及私聊
+type Grant struct {
+	ID       int    `gorm:"primarykey;AUTO_INCREMENT" json:"id"`
+	Username string `gorm:"uniqueIndex:idx_grant_user_gid;size:191;not null" json:"username"`
+	Gid      string `gorm:"uniqueIndex:idx_grant_user_gid;size:40;not null" json:"gid"`
+}
+
+func (Grant) TableName() string {
+	return "auth_grant"
+}
+
+// AllowGroup 是否可以访问群,gid为空表示私聊,只有不受群限制的身份可以访问
+func (i *Identity) AllowGroup(gid string) bool {
+	if i == nil || i.Groups == nil {
+		return true
+	}
+	return gid != "" && slices.Contains(i.Groups, gid)
+}
+
+// AddGrant 授权用户访问群
+func (m *Manager) AddGrant(username string, gid string) (*Grant, error) {
+	gid = strings.TrimSpace(gid)
+	if gid == "" {
+		return nil, errors.New("群id不能为空")
+	}
+	if user, err := m.FindUser(username); err != nil {
+		return nil, err
+	} else if user == nil {
+		return nil, errors.New("用户不存在")
+	}
+	grant := &Grant{Username: username, Gid: gid}
+	if err := m.db.Create(grant).Error; err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// ListGrants 获取群授权,username为空时返回全部
+func (m *Manager) ListGrants(username string) ([]Grant, error) {
+	db := m.db.Order("username").Order("id")
+	if username != "" {
+		db = db.Where("username = ?", username)
+	}
+	var grants []Grant
+	err := db.Find(&grants).Error
+	return grants, err
+}
+
+// DeleteGrant 删除群授权
+func (m *Manager) DeleteGrant(id int) error {
+	return m.db.Delete(&Grant{}, id).Error
+}
+
+// userGroups 用户可以访问的群,为nil时不限制
+func (m *Manager) userGroups(username string) ([]string, error) {
+	grants, err := m.ListGrants(username)
+	if err != nil || len(grants) == 0 {
+		return nil, err
+	}
+	groups := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		groups = append(groups, grant.Gid)
+	}
+	return groups, nil
+}
+
+// intersectGroups 令牌的群与用户的群授权的交集,userGroups为nil时用户不受限制
+func intersectGroups(tokenGroups, userGroups []string) []string {
+	if userGroups == nil {
+		return tokenGroups
+	}
+	groups := make([]string, 0, len(tokenGroups))
+	for _, gid := range tokenGroups {
+		if slices.Contains(userGroups, gid) {
+			groups = append(groups, gid)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword 使用bcrypt生成密码哈希
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// verifyPassword 校验密码,旧版MD5哈希校验通过时upgrade为true,需要重新生成哈希
+func verifyPassword(hash string, password string) (ok bool, upgrade bool) {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, false
+	}
+	legacy := fmt.Sprintf("%x", md5.Sum([]byte(password)))
+	ok = subtle.ConstantTimeCompare([]byte(hash), []byte(legacy)) == 1
+	return ok, ok
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 权限范围,admin包含所有权限
+const (
+	ScopeSend         = "send"          // 发送、撤回消息
+	ScopeReadMessages = "read:messages" // 接收实时消息
+	ScopeReadHistory  = "read:history"  // 查询历史消息及资源
+	ScopeReadMembers  = "read:members"  // 查询群及成员
+	ScopeAdmin        = "admin"         // 管理权限、投递箱、重放等
+)
+
+// TokenPrefix API令牌前缀,用于区分令牌和密码
+const TokenPrefix = "wh_"
+
+var (
+	Scopes          = []string{ScopeSend, ScopeReadMessages, ScopeReadHistory, ScopeReadMembers, ScopeAdmin}
+	ErrInvalidToken = errors.New("令牌无效或已过期")
+)
+
+type (
+	// Token API令牌,只保存令牌的哈希
+	Token struct {
+		ID        int    `gorm:"primarykey;AUTO_INCREMENT" json:"id"`
+		Username  string `gorm:"index;not null" json:"username"`
+		Name      string `json:"name"`
+		Hash      string `gorm:"uniqueIndex;size:64;not null" json:"-"`
+		Prefix    string `json:"prefix"` // 令牌前几位,便于识别
+		Scopes    string `gorm:"not null" json:"scopes"`
+		Groups    string `json:"groups,omitempty"` // 可以访问的群,为空时与用户一致
+		ExpiresAt int64  `json:"expiresAt"`        // 过期时间,毫秒时间戳,0为永不过期
+		CreatedAt int64  `gorm:"autoCreateTime:milli" json:"createdAt"`
+	}
+
+	// Identity 认证后的身份
+	Identity struct {
+		Username string   `json:"username"`
+		TokenID  int      `json:"tokenId,omitempty"` // 使用令牌认证时的令牌id
+		Scopes   []string `json:"scopes"`            // 为nil时不限制
+		Groups   []string `json:"groups,omitempty"`  // 可以访问的群,为nil时不限制
+		// 令牌的过期时间,毫秒时间戳,0为永不过期或密码认证
+		ExpiresAt int64 `json:"expiresAt,omitempty"`
+	}
+)
+
+func (Token) TableName() string {
+	return "auth_token"
+}
+
+// Allow 是否拥有权限,nil表示未启用认证
+func (i *Identity) Allow(scope string) bool {
+	if i == nil || i.Scopes == nil {
+		return true
+	}
+	return slices.Contains(i.Scopes, ScopeAdmin) || slices.Contains(i.Scopes, scope)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken 为用户创建令牌,令牌明文只在创建时返回,groups为空时与用户的群授权一致,expiresAt为零值时永不过期
+func (m *Manager) CreateToken(username string, name string, scopes []string, groups []string, expiresAt time.Time) (string, *Token, error) {
+	if len(scopes) == 0 {
+		return "", nil, errors.New("权限范围不能为空")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", nil, errors.New("未知的权限范围: " + scope)
+		}
+	}
+	if !expiresAt.IsZero() && expiresAt.Before(time.Now()) {
+		return "", nil, errors.New("过期时间不能早于当前时间")
+	}
+	if user, err := m.FindUser(username); err != nil {
+		return "", nil, err
+	} else if user == nil {
+		return "", nil, errors.New("用户不存在")
+	} else if !user.Admin && slices.Contains(scopes, ScopeAdmin) {
+		return "", nil, errors.New("用户不是管理员,不能创建admin权限的令牌")
+	}
+	if len(groups) > 0 {
+		userGroups, err := m.userGroups(username)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, gid := range groups {
+			if userGroups != nil && !slices.Contains(userGroups, gid) {
+				return "", nil, errors.New("用户没有群的授权: " + gid)
+			}
+		}
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	plain := TokenPrefix + hex.EncodeToString(buf)
+	token := &Token{
+		Username: username,
+		Name:     name,
+		Hash:     hashToken(plain),
+		Prefix:   plain[:len(TokenPrefix)+6],
+		Scopes:   strings.Join(scopes, ","),
+		Groups:   strings.Join(groups, ","),
+	}
+	if !expiresAt.IsZero() {
+		token.ExpiresAt = expiresAt.UnixMilli()
+	}
+	if err := m.db.Create(token).Error; err != nil {
+		return "", nil, err
+	}
+	return plain, token, nil
+}
+
+// ListTokens 获取令牌,username为空时返回全部
+func (m *Manager) ListTokens(username string) ([]Token, error) {
+	db := m.db.Order("id")
+	if username != "" {
+		db = db.Where("username = ?", username)
+	}
+	var tokens []Token
+	err := db.Find(&tokens).Error
+	return tokens, err
+}
+
+// FindToken 根据id获取令牌
+func (m *Manager) FindToken(id int) (*Token, error) {
+	var token Token
+	err := m.db.Take(&token, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &token, err
+}
+
+// RevokeToken 吊销令牌
+func (m *Manager) RevokeToken(id int) error {
+	return m.db.Delete(&Token{}, id).Error
+}
+
+// CheckToken 校验令牌,返回令牌对应的身份
+func (m *Manager) CheckToken(plain string) (*Identity, error) {
+	if !strings.HasPrefix(plain, TokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	var token Token
+	err := m.db.Where("hash = ?", hashToken(plain)).Take(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt > 0 && token.ExpiresAt < time.Now().UnixMilli() {
+		return nil, ErrInvalidToken
+	}
+	identity := &Identity{
+		Username:  token.Username,
+		TokenID:   token.ID,
+		Scopes:    strings.Split(token.Scopes, ","),
+		ExpiresAt: token.ExpiresAt,
+	}
+	if slices.Contains(identity.Scopes, ScopeAdmin) {
+		// 用户取消管理员后已有的令牌也失去管理权限
+		user, err := m.FindUser(token.Username)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || !user.Admin {
+			identity.Scopes = withoutAdmin(identity.Scopes)
+		}
+	}
+	// 每次都与用户当前的群授权取交集,用户被取消授权后已有的令牌也不能再访问该群
+	if identity.Groups, err = m.userGroups(token.Username); err != nil {
+		return nil, err
+	}
+	if token.Groups != "" {
+		identity.Groups = intersectGroups(strings.Split(token.Groups, ","), identity.Groups)
+	}
+	return identity, nil
+}
+
+// Authenticate 使用用户名密码认证,密码也可以是该用户的令牌,不是有效令牌时按密码校验
+func (m *Manager) Authenticate(username string, password string) (*Identity, error) {
+	if strings.HasPrefix(password, TokenPrefix) {
+		identity, err := m.CheckToken(password)
+		if err == nil {
+			if username != "" && username != identity.Username {
+				return nil, ErrInvalidToken
+			}
+			return identity, nil
+		}
+		if !errors.Is(err, ErrInvalidToken) {
+			return nil, err
+		}
+	}
+	if !m.CheckUser(username, password) {
+		return nil, errors.New("用户名或密码错误")
+	}
+	return m.userIdentity(username)
+}
+
+// userIdentity 密码认证的用户拥有除管理外的全部权限,管理员才有admin权限
+func (m *Manager) userIdentity(username string) (*Identity, error) {
+	user, err := m.FindUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("用户不存在")
+	}
+	groups, err := m.userGroups(username)
+	if err != nil {
+		return nil, err
+	}
+	identity := &Identity{Username: username, Scopes: slices.Clone(Scopes), Groups: groups}
+	if !user.Admin {
+		identity.Scopes = withoutAdmin(identity.Scopes)
+	}
+	return identity, nil
+}
+
+func withoutAdmin(scopes []string) []string {
+	return slices.DeleteFunc(scopes, func(scope string) bool {
+		return scope == ScopeAdmin
+	})
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func testManager(t *testing.T) *Manager {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/auth.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthManager(db)
+}
+
+func TestCheckToken(t *testing.T) {
+	m := testManager(t)
+	_ = m.CreateUser("root", "pw", true)
+	_ = m.CreateUser("alice", "pw", false)
+	send, _, err := m.CreateToken("alice", "send", []string{ScopeSend, ScopeReadHistory}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring, _, err := m.CreateToken("alice", "expiring", []string{ScopeSend}, nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, token, err := m.CreateToken("alice", "expired", []string{ScopeSend}, nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.db.Model(token).Update("expires_at", time.Now().Add(-time.Second).UnixMilli())
+	revoked, token, err := m.CreateToken("alice", "revoked", []string{ScopeSend}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.RevokeToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := m.CreateToken("root", "admin", []string{ScopeAdmin}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		plain  string
+		ok     bool
+		scopes []string // 检查的权限
+		allow  []bool
+	}{
+		{"scopes", send, true, []string{ScopeSend, ScopeReadHistory, ScopeReadMessages, ScopeAdmin}, []bool{true, true, false, false}},
+		{"expiring", expiring, true, []string{ScopeSend}, []bool{true}},
+		{"expired", expired, false, nil, nil},
+		{"revoked", revoked, false, nil, nil},
+		{"admin", admin, true, []string{ScopeAdmin, ScopeReadMembers}, []bool{true, true}},
+		{"unknown", TokenPrefix + "0000", false, nil, nil},
+		{"no prefix", "password", false, nil, nil},
+	}
+	for _, c := range cases {
+		identity, err := m.CheckToken(c.plain)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: CheckToken() err = %v, want ok %v", c.name, err, c.ok)
+			continue
+		}
+		for i, scope := range c.scopes {
+			if allow := identity.Allow(scope); allow != c.allow[i] {
+				t.Errorf("%s: Allow(%s) = %v, want %v", c.name, scope, allow, c.allow[i])
+			}
+		}
+	}
+	// 取消管理员后已有的令牌失去管理权限
+	if err = m.SetAdmin("root", false); err != nil {
+		t.Fatal(err)
+	}
+	if identity, err := m.CheckToken(admin); err != nil || identity.Allow(ScopeAdmin) {
+		t.Errorf("CheckToken() after revoking admin = %+v, %v", identity, err)
+	}
+	// 删除用户后令牌失效
+	if err = m.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.CheckToken(send); err == nil {
+		t.Error("CheckToken() accepted a token of a deleted user")
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	m := testManager(t)
+	_ = m.CreateUser("alice", "pw", false)
+	if _, err := m.AddGrant("alice", "g1"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		username  string
+		scopes    []string
+		groups    []string
+		expiresAt time.Time
+		ok        bool
+	}{
+		{"ok", "alice", []string{ScopeSend}, []string{"g1"}, time.Time{}, true},
+		{"no scopes", "alice", nil, nil, time.Time{}, false},
+		{"unknown scope", "alice", []string{"write"}, nil, time.Time{}, false},
+		{"admin scope", "alice", []string{ScopeAdmin}, nil, time.Time{}, false},
+		{"ungranted group", "alice", []string{ScopeSend}, []string{"g2"}, time.Time{}, false},
+		{"expired", "alice", []string{ScopeSend}, nil, time.Now().Add(-time.Minute), false},
+		{"no user", "bob", []string{ScopeSend}, nil, time.Time{}, false},
+	}
+	for _, c := range cases {
+		if _, _, err := m.CreateToken(c.username, c.name, c.scopes, c.groups, c.expiresAt); (err == nil) != c.ok {
+			t.Errorf("%s: CreateToken() err = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestTokenGroupsFollowGrants(t *testing.T) {
+	m := testManager(t)
+	if err := m.CreateUser("alice", "secret", false); err != nil {
+		t.Fatal(err)
+	}
+	g1, _ := m.AddGrant("alice", "g1")
+	if _, err := m.AddGrant("alice", "g2"); err != nil {
+		t.Fatal(err)
+	}
+	scoped, _, err := m.CreateToken("alice", "scoped", []string{ScopeSend}, []string{"g1", "g2"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherit, _, err := m.CreateToken("alice", "inherit", []string{ScopeSend}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteGrant(g1.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{scoped, inherit} {
+		identity, err := m.CheckToken(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(identity.Groups, []string{"g2"}) {
+			t.Errorf("CheckToken().Groups = %v, want [g2]", identity.Groups)
+		}
+		if identity.AllowGroup("g1") {
+			t.Errorf("revoked group g1 is still allowed")
+		}
+	}
+}
+
+func TestIntersectGroups(t *testing.T) {
+	cases := []struct {
+		token  []string
+		user   []string
+		expect []string
+	}{
+		{[]string{"g1", "g2"}, nil, []string{"g1", "g2"}},
+		{[]string{"g1", "g2"}, []string{"g2", "g3"}, []string{"g2"}},
+		{[]string{"g1"}, []string{"g3"}, []string{}},
+	}
+	for _, c := range cases {
+		if groups := intersectGroups(c.token, c.user); !reflect.DeepEqual(groups, c.expect) {
+			t.Errorf("intersectGroups(%v, %v) = %v, want %v", c.token, c.user, groups, c.expect)
+		}
+	}
+}
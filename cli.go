@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"wechat-hub/auth"
+	"wechat-hub/hub"
+	"wechat-hub/redirect"
+
+	"github.com/eatmoreapple/openwechat"
+	"gorm.io/gorm"
+)
+
+const cliUsage = `用法: wechat-hub [命令]
+
+不带命令时启动服务,命令只操作数据库,不会启动机器人:
+  user add <用户名> [-admin]                创建用户,密码从标准输入读取,-admin 为管理员
+  user passwd <用户名>                      修改密码,密码从标准输入读取
+  user admin <用户名> [-revoke]             设为管理员,-revoke 取消管理员
+  user delete <用户名>                      删除用户及其令牌、权限、群授权
+  user list                                 用户列表
+  token create <用户名> -scopes send,... [-name 名称] [-groups gid,...] [-expires 720h]
+  token list [用户名]                       令牌列表
+  token revoke <令牌id>                     吊销令牌
+  migrate                                   创建或升级数据表
+  member refresh                            使用已保存的登录信息刷新群成员,不要在服务运行时执行
+  export messages [-gid] [-uid] [-type] [-start] [-end] [-o 文件]  导出消息,每行一条JSON
+  export members -gid <群id> [-o 文件]      导出群成员,每行一条JSON
+`
+
+// cliCommands 命令行子命令
+var cliCommands = map[string]func(args []string) error{
+	"user":    userCommand,
+	"token":   tokenCommand,
+	"migrate": func([]string) error { return migrateCommand() },
+	"member":  memberCommand,
+	"export":  exportCommand,
+}
+
+// runCommand 执行命令行子命令,返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	}
+	command, ok := cliCommands[args[0]]
+	var err error
+	if !ok {
+		err = fmt.Errorf("未知的命令: %s", args[0])
+	} else if err = loadConfig(); err == nil {
+		// 配置在确定需要时才读取,配置文件有误时也可以查看帮助
+		err = command(args[1:])
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "错误:", err)
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, cliUsage)
+		}
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("参数错误")
+
+// parseFlags 解析子命令参数,位置参数可以在选项之前
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func userCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	admin := fs.Bool("admin", false, "创建为管理员")
+	revoke := fs.Bool("revoke", false, "取消管理员")
+	positional, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	manager := auth.NewAuthManager(connectDB())
+	switch args[0] {
+	case "add":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		if user, err := manager.FindUser(positional[0]); err != nil {
+			return err
+		} else if user != nil {
+			return fmt.Errorf("用户 %s 已存在", positional[0])
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := manager.CreateUser(positional[0], password, *admin); err != nil {
+			return err
+		}
+		fmt.Println("已创建用户", positional[0])
+	case "passwd":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := manager.SetPassword(positional[0], password); errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("用户 %s 不存在", positional[0])
+		} else if err != nil {
+			return err
+		}
+		fmt.Println("已修改密码", positional[0])
+	case "admin":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		if err := manager.SetAdmin(positional[0], !*revoke); errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("用户 %s 不存在", positional[0])
+		} else if err != nil {
+			return err
+		}
+		if *revoke {
+			fmt.Println("已取消管理员", positional[0])
+		} else {
+			fmt.Println("已设为管理员", positional[0])
+		}
+	case "delete":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		if user, err := manager.FindUser(positional[0]); err != nil {
+			return err
+		} else if user == nil {
+			return fmt.Errorf("用户 %s 不存在", positional[0])
+		}
+		if err := manager.DeleteUser(positional[0]); err != nil {
+			return err
+		}
+		fmt.Println("已删除用户", positional[0])
+	case "list":
+		users, err := manager.ListUsers()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\t用户名\t管理员")
+		for _, user := range users {
+			fmt.Fprintf(w, "%d\t%s\t%t\n", user.ID, user.Username, user.Admin)
+		}
+		return w.Flush()
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+// readPassword 从标准输入读取一行作为密码,可以通过管道传入,不通过命令行参数传递以免出现在进程列表及历史中
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "密码: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("密码不能为空")
+	}
+	return password, nil
+}
+
+func tokenCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	name := fs.String("name", "", "令牌名称")
+	scopes := fs.String("scopes", "", "权限范围,多个用逗号分隔: "+strings.Join(auth.Scopes, ","))
+	groups := fs.String("groups", "", "可以访问的群id,多个用逗号分隔,为空时与用户一致")
+	expires := fs.Duration("expires", 0, "有效期,为0时永不过期")
+	positional, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	manager := auth.NewAuthManager(connectDB())
+	switch args[0] {
+	case "create":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		var expiresAt time.Time
+		if *expires > 0 {
+			expiresAt = time.Now().Add(*expires)
+		}
+		plain, token, err := manager.CreateToken(positional[0], *name, splitList(*scopes), splitList(*groups), expiresAt)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已创建令牌 %d,令牌只显示一次,请妥善保存:\n%s\n", token.ID, plain)
+	case "list":
+		if len(positional) > 1 {
+			return errUsage
+		}
+		var username string
+		if len(positional) == 1 {
+			username = positional[0]
+		}
+		tokens, err := manager.ListTokens(username)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\t用户名\t名称\t前缀\t权限\t群\t过期时间")
+		for _, token := range tokens {
+			expiresAt := "永不过期"
+			if token.ExpiresAt > 0 {
+				expiresAt = time.UnixMilli(token.ExpiresAt).Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.Username, token.Name, token.Prefix, token.Scopes, token.Groups, expiresAt)
+		}
+		return w.Flush()
+	case "revoke":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		var id int
+		if _, err := fmt.Sscan(positional[0], &id); err != nil {
+			return fmt.Errorf("令牌id错误: %s", positional[0])
+		}
+		if token, err := manager.FindToken(id); err != nil {
+			return err
+		} else if token == nil {
+			return fmt.Errorf("令牌 %d 不存在", id)
+		}
+		if err := manager.RevokeToken(id); err != nil {
+			return err
+		}
+		fmt.Println("已吊销令牌", id)
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// migrateCommand 各管理器创建时会自动迁移数据表
+func migrateCommand() error {
+	db := connectDB()
+	auth.NewAuthManager(db)
+	hub.NewMemberManger(nil, db)
+	hub.NewMessageManager(db)
+	hub.NewOutboxManager(db)
+	hub.NewAuditManager(db)
+	if err := redirect.Migrate(db); err != nil {
+		return err
+	}
+	// 开启全文索引时同步补建,完成后再退出
+	if cfg.Message.FTS {
+		count, err := hub.BuildMessageIndex(db)
+		if err != nil {
+			return fmt.Errorf("补建消息索引失败: %w", err)
+		}
+		fmt.Println("已补建消息索引", count)
+	}
+	fmt.Println("数据表迁移完成")
+	return nil
+}
+
+// memberCommand 使用已保存的登录信息登录,登录失效时不会扫码
+func memberCommand(args []string) error {
+	if len(args) != 1 || args[0] != "refresh" {
+		return errUsage
+	}
+	db := connectDB()
+	bot := openwechat.NewBot(context.Background())
+	openwechat.Desktop.Prepare(bot)
+	if err := bot.HotLogin(openwechat.NewFileHotReloadStorage(path.Join(cfg.Data, "login.json"))); err != nil {
+		return fmt.Errorf("登录失败,请先启动服务扫码登录: %w", err)
+	}
+	memberManager := hub.NewMemberManger(bot, db)
+	if memberManager.RefreshGroupMember() == nil {
+		return errors.New("刷新群成员失败")
+	}
+	fmt.Println("群成员刷新完成")
+	return nil
+}
+
+func exportCommand(args []string) error {
+	// 先校验子命令,避免无效的命令也创建出输出文件
+	if len(args) == 0 || (args[0] != "messages" && args[0] != "members") {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("export "+args[0], flag.ContinueOnError)
+	output := fs.String("o", "", "输出文件,为空时输出到标准输出")
+	var q hub.MessageQuery
+	fs.StringVar(&q.Gid, "gid", "", "群id")
+	fs.StringVar(&q.Uid, "uid", "", "用户id")
+	fs.IntVar(&q.Type, "type", 0, "消息类型")
+	start := fs.String("start", "", "开始时间,格式 2006-01-02 15:04:05")
+	end := fs.String("end", "", "结束时间,格式 2006-01-02 15:04:05")
+	positional, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 || (args[0] == "members" && q.Gid == "") {
+		return errUsage
+	}
+	if q.Start, err = parseTime(*start); err != nil {
+		return err
+	}
+	if q.End, err = parseTime(*end); err != nil {
+		return err
+	}
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	db := connectDB()
+	var count int
+	switch args[0] {
+	case "messages":
+		err = hub.NewMessageManager(db).Each(&q, func(message hub.StoredMessage) error {
+			count++
+			return encoder.Encode(message)
+		})
+	case "members":
+		var users map[string]hub.GroupUser
+		if users, err = hub.NewMemberManger(nil, db).GetGroupUsers(q.Gid); err == nil {
+			for _, user := range users {
+				count++
+				if err = encoder.Encode(user); err != nil {
+					break
+				}
+			}
+		}
+	}
+	err = errors.Join(err, w.Flush())
+	if out != os.Stdout {
+		err = errors.Join(err, out.Close())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "已导出 %d 条记录\n", count)
+	return nil
+}
+
+// parseTime 解析本地时间为秒级时间戳,为空时返回0
+func parseTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.ParseInLocation(time.DateTime, s, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误: %s", s)
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"wechat-hub/auth"
+	"wechat-hub/hub"
+)
+
+// commandHandler 命令处理器,identity为命令来源的身份,actor为审计记录的操作者,返回的数据作为命令结果
+type commandHandler func(h *Hub, identity *auth.Identity, actor hub.Actor, param json.RawMessage) (any, error)
+
+var commandHandlers = map[string]commandHandler{
+	hub.CommandSendMessage:      sendMessageCommand,
+	hub.CommandRevokeMessage:    revokeMessageCommand,
+	hub.CommandListGroups:       listGroupsCommand,
+	hub.CommandListGroupMembers: listGroupMembersCommand,
+	hub.CommandQueryMessages:    queryMessagesCommand,
+	hub.CommandBotStatus:        botStatusCommand,
+	hub.CommandRefreshMembers:   refreshMembersCommand,
+}
+
+// commandScopes 执行命令需要的权限,未列出的命令不需要权限
+var commandScopes = map[string]string{
+	hub.CommandSendMessage:      auth.ScopeSend,
+	hub.CommandRevokeMessage:    auth.ScopeSend,
+	hub.CommandListGroups:       auth.ScopeReadMembers,
+	hub.CommandListGroupMembers: auth.ScopeReadMembers,
+	hub.CommandQueryMessages:    auth.ScopeReadHistory,
+	hub.CommandRefreshMembers:   auth.ScopeAdmin,
+}
+
+// decodeParam 解析并校验命令参数
+func decodeParam[T any, P interface {
+	*T
+	hub.CommandParam
+}](raw json.RawMessage) (P, error) {
+	param := P(new(T))
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, param); err != nil {
+			return nil, fmt.Errorf("%w: 参数解析失败 %w", errBadCommand, err)
+		}
+	}
+	if err := param.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadCommand, err)
+	}
+	return param, nil
+}
+
+// newActor 根据认证身份生成审计的操作者,redirector为命令来源的转发器名称,HTTP接口为空
+func newActor(identity *auth.Identity, channel, redirector string) hub.Actor {
+	actor := hub.Actor{Channel: channel, Redirector: redirector}
+	if identity != nil {
+		actor.Username = identity.Username
+		actor.TokenID = identity.TokenID
+	}
+	return actor
+}
+
+func sendMessageCommand(h *Hub, identity *auth.Identity, actor hub.Actor, raw json.RawMessage) (any, error) {
+	param, err := decodeParam[hub.SendMsgCommand](raw)
+	if err != nil {
+		return nil, err
+	}
+	if !identity.AllowGroup(param.Gid) {
+		return nil, fmt.Errorf("%w: 没有群的授权", errForbidden)
+	}
+	msgID, err := h.sender.SendMsg(param, actor)
+	if err != nil {
+		return nil, err
+	}
+	return hub.SendMsgResult{MsgID: msgID}, nil
+}
+
+func revokeMessageCommand(h *Hub, identity *auth.Identity, actor hub.Actor, raw json.RawMessage) (any, error) {
+	param, err := decodeParam[hub.RevokeMsgCommand](raw)
+	if err != nil {
+		return nil, err
+	}
+	// 不知道群id的消息(私聊、已过期或未记录)受群限制的身份不能撤回
+	if gid, _ := h.sender.SentGroup(param.MsgID); !identity.AllowGroup(gid) {
+		return nil, fmt.Errorf("%w: 没有群的授权", errForbidden)
+	}
+	return nil, h.sender.Revoke(param.MsgID, actor)
+}
+
+func listGroupsCommand(h *Hub, identity *auth.Identity, _ hub.Actor, _ json.RawMessage) (any, error) {
+	groups, err := h.member.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(groups, func(group hub.Group) bool {
+		return !identity.AllowGroup(group.GID)
+	}), nil
+}
+
+func listGroupMembersCommand(h *Hub, identity *auth.Identity, _ hub.Actor, raw json.RawMessage) (any, error) {
+	param, err := decodeParam[hub.GroupMembersCommand](raw)
+	if err != nil {
+		return nil, err
+	}
+	if !identity.AllowGroup(param.Gid) {
+		return nil, fmt.Errorf("%w: 没有群的授权", errForbidden)
+	}
+	userMap, err := h.member.GetGroupUsers(param.Gid)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]hub.GroupUser, 0, len(userMap))
+	for _, user := range userMap {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func queryMessagesCommand(h *Hub, identity *auth.Identity, _ hub.Actor, raw json.RawMessage) (any, error) {
+	param, err := decodeParam[hub.MessageQuery](raw)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		param.Gids = identity.Groups
+	}
+	return h.message.Query(param)
+}
+
+func botStatusCommand(h *Hub, _ *auth.Identity, _ hub.Actor, _ json.RawMessage) (any, error) {
+	return h.botStatus()
+}
+
+func refreshMembersCommand(h *Hub, _ *auth.Identity, _ hub.Actor, _ json.RawMessage) (any, error) {
+	if !h.sender.Bot.Alive() {
+		return nil, errors.New("bot已掉线")
+	}
+	h.watchMembersAndNotify()
+	return nil, nil
+}
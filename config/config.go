@@ -0,0 +1,411 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wechat-hub/hub"
+	"wechat-hub/redirect"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// Config 配置,加载顺序为 默认值 -> 配置文件 -> 环境变量
+	Config struct {
+		Data      string       `yaml:"data"` // 数据目录
+		DB        DB           `yaml:"db"`
+		Storage   Storage      `yaml:"storage"`
+		HTTP      HTTP         `yaml:"http"`
+		Sender    Sender       `yaml:"sender"`
+		Message   Message      `yaml:"message"`
+		Members   Members      `yaml:"members"`
+		Outbox    Outbox       `yaml:"outbox"`
+		Redirects []Redirector `yaml:"redirects"`
+	}
+
+	DB struct {
+		Type  string `yaml:"type"` // sqlite或mysql
+		Path  string `yaml:"path"` // sqlite数据库文件,默认为 {data}/database.sqlite
+		MySQL MySQL  `yaml:"mysql"`
+	}
+
+	MySQL struct {
+		Host       string `yaml:"host"`
+		Port       int    `yaml:"port"`
+		Username   string `yaml:"username"`
+		Password   string `yaml:"password"`
+		Database   string `yaml:"database"`
+		Parameters string `yaml:"parameters"` // 连接参数,如 charset=utf8mb4&parseTime=true
+	}
+
+	Storage struct {
+		Path string `yaml:"path"` // 资源文件目录,默认为 {data}/files
+	}
+
+	HTTP struct {
+		Port          int  `yaml:"port"`
+		MaxUploadSize Size `yaml:"maxUploadSize"` // 上传文件大小限制,如 20MB
+	}
+
+	// Sender 发送限流,每interval允许发送一条,最多累积burst条
+	Sender struct {
+		Interval time.Duration `yaml:"interval"`
+		Burst    int           `yaml:"burst"`
+	}
+
+	Message struct {
+		FTS bool `yaml:"fts"` // 全文索引
+	}
+
+	Members struct {
+		Schedules []string `yaml:"schedules"` // 刷新群成员的cron表达式,包含秒
+	}
+
+	Outbox struct {
+		MaxAttempts int           `yaml:"maxAttempts"` // 最大重试次数
+		Backoff     time.Duration `yaml:"backoff"`     // 重试间隔,每次失败翻倍
+		MaxBackoff  time.Duration `yaml:"maxBackoff"`  // 最大重试间隔
+	}
+
+	// Redirector 转发器配置,除name、type外的字段为对应类型的配置
+	Redirector struct {
+		Name    string         `yaml:"name"`   // 名称,用于投递记录及重放,不能重复
+		Type    string         `yaml:"type"`   // 类型,见 redirect.Types
+		Filter  *hub.Filter    `yaml:"filter"` // 消息过滤规则,为空时转发所有消息
+		Options map[string]any `yaml:",inline"`
+
+		Spec *redirect.Spec `yaml:"-"` // 校验通过后解析的配置
+	}
+)
+
+// Default 默认配置
+func Default() *Config {
+	return &Config{
+		Data: "./data",
+		HTTP: HTTP{
+			Port:          8080,
+			MaxUploadSize: 20 << 20,
+		},
+		Sender: Sender{
+			Interval: time.Second,
+			Burst:    1,
+		},
+		Members: Members{
+			Schedules: []string{"0 0/5 6-23 * * *", "0 0/30 0-6 * * *"},
+		},
+		Outbox: Outbox{
+			MaxAttempts: 20,
+			Backoff:     5 * time.Second,
+			MaxBackoff:  10 * time.Minute,
+		},
+		DB: DB{
+			MySQL: MySQL{
+				Host:     "127.0.0.1",
+				Port:     3306,
+				Username: "root",
+				Password: "root",
+			},
+		},
+		// 未配置转发器时启用websocket服务端、MQTT及HTTP事件流,事件流默认不保存积压,避免额外的数据库写入
+		Redirects: []Redirector{
+			{Name: "WS_SERVER", Type: "websocketServer"},
+			{Name: "MQTT", Type: "mqtt"},
+			{Name: "SSE", Type: "sse", Options: map[string]any{"backlog": "0s"}},
+		},
+	}
+}
+
+// Load 加载配置,file为空时使用环境变量CONFIG,仍为空时尝试 {data}/config.yaml
+func Load(file string) (*Config, error) {
+	c := Default()
+	if dir := os.Getenv("DATA"); dir != "" {
+		c.Data = dir
+	}
+	if file == "" {
+		file = os.Getenv("CONFIG")
+	}
+	if file == "" {
+		if _, err := os.Stat(path.Join(c.Data, "config.yaml")); err == nil {
+			file = path.Join(c.Data, "config.yaml")
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件失败: %w", err)
+		}
+		// 未知的字段视为错误,避免拼写错误的配置被忽略
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %w", file, err)
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if c.DB.Path == "" {
+		c.DB.Path = path.Join(c.Data, "database.sqlite")
+	}
+	if c.Storage.Path == "" {
+		c.Storage.Path = path.Join(c.Data, "files")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyEnv 环境变量覆盖配置文件
+func (c *Config) applyEnv() error {
+	var errs []error
+	// 为空的环境变量视为未设置
+	str := func(name string, v *string) {
+		if s := os.Getenv(name); s != "" {
+			*v = s
+		}
+	}
+	num := func(name string, v *int) {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("环境变量 %s: %q 不是有效的整数", name, s))
+				return
+			}
+			*v = n
+		}
+	}
+	str("DATA", &c.Data)
+	num("APP_PORT", &c.HTTP.Port)
+	// 转发器相关的环境变量作用于第一个对应类型的转发器,没有该类型的转发器时报错,避免设置被忽略
+	redirectOption := func(name string, typ string, key string, value any) {
+		for i := range c.Redirects {
+			if r := &c.Redirects[i]; r.Type == typ {
+				if r.Options == nil {
+					r.Options = map[string]any{}
+				}
+				r.Options[key] = value
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("环境变量 %s: 没有配置 %s 类型的转发器", name, typ))
+	}
+	for _, env := range []struct{ name, typ, key string }{
+		{"WS_PORT", "websocketServer", "port"},
+		{"MQTT_PORT", "mqtt", "port"},
+		{"MQTT_WS_PORT", "mqtt", "wsPort"},
+	} {
+		var port int
+		if num(env.name, &port); port != 0 {
+			redirectOption(env.name, env.typ, env.key, port)
+		}
+	}
+	if s := os.Getenv("MQTT_STORE"); s != "" {
+		redirectOption("MQTT_STORE", "mqtt", "store", s)
+	}
+	if s := os.Getenv("WEBHOOK_URLS"); s != "" {
+		var urls []string
+		for _, u := range strings.Split(s, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if !slices.ContainsFunc(c.Redirects, func(r Redirector) bool { return r.Type == "webhook" }) {
+			c.Redirects = append(c.Redirects, Redirector{Name: "WEBHOOK", Type: "webhook"})
+		}
+		redirectOption("WEBHOOK_URLS", "webhook", "urls", urls)
+	}
+	if s := os.Getenv("WEBHOOK_SECRET"); s != "" {
+		redirectOption("WEBHOOK_SECRET", "webhook", "secret", s)
+	}
+	if s := os.Getenv("MESSAGE_FTS"); s != "" {
+		if fts, err := strconv.ParseBool(s); err != nil {
+			errs = append(errs, fmt.Errorf("环境变量 MESSAGE_FTS: %q 不是有效的布尔值", s))
+		} else {
+			c.Message.FTS = fts
+		}
+	}
+	str("DB", &c.DB.Type)
+	str("MYSQL_HOST", &c.DB.MySQL.Host)
+	num("MYSQL_PORT", &c.DB.MySQL.Port)
+	str("MYSQL_USERNAME", &c.DB.MySQL.Username)
+	str("MYSQL_PASSWORD", &c.DB.MySQL.Password)
+	str("MYSQL_DATABASE", &c.DB.MySQL.Database)
+	str("MYSQL_PARAMETERS", &c.DB.MySQL.Parameters)
+	return errors.Join(errs...)
+}
+
+// Validate 校验配置,返回所有错误
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	if c.Data == "" {
+		fail("data", "不能为空")
+	}
+	switch c.DB.Type {
+	case "", "sqlite":
+	case "mysql":
+		if c.DB.MySQL.Host == "" {
+			fail("db.mysql.host", "不能为空")
+		}
+		if !validPort(c.DB.MySQL.Port) {
+			fail("db.mysql.port", "端口 %d 无效", c.DB.MySQL.Port)
+		}
+		if c.DB.MySQL.Database == "" {
+			fail("db.mysql.database", "不能为空")
+		}
+	default:
+		fail("db.type", "未知的数据库类型 %q,可选 sqlite、mysql", c.DB.Type)
+	}
+	if c.HTTP.MaxUploadSize <= 0 {
+		fail("http.maxUploadSize", "必须大于0")
+	}
+	if c.Sender.Interval <= 0 {
+		fail("sender.interval", "必须大于0")
+	}
+	if c.Sender.Burst < 1 {
+		fail("sender.burst", "不能小于1")
+	}
+	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	for i, spec := range c.Members.Schedules {
+		if _, err := parser.Parse(spec); err != nil {
+			fail(fmt.Sprintf("members.schedules[%d]", i), "cron表达式 %q 无效: %v", spec, err)
+		}
+	}
+	if c.Outbox.MaxAttempts < 1 {
+		fail("outbox.maxAttempts", "不能小于1")
+	}
+	if c.Outbox.Backoff <= 0 {
+		fail("outbox.backoff", "必须大于0")
+	}
+	if c.Outbox.MaxBackoff < c.Outbox.Backoff {
+		fail("outbox.maxBackoff", "不能小于 outbox.backoff")
+	}
+
+	// 监听端口不能重复
+	ports := map[int]string{}
+	listen := func(field string, port int) {
+		if !validPort(port) {
+			fail(field, "端口 %d 无效", port)
+			return
+		}
+		if other, ok := ports[port]; ok {
+			fail(field, "端口 %d 与 %s 重复", port, other)
+			return
+		}
+		ports[port] = field
+	}
+	listen("http.port", c.HTTP.Port)
+	names := map[string]bool{}
+	streams := 0
+	for i := range c.Redirects {
+		r := &c.Redirects[i]
+		field := fmt.Sprintf("redirects[%d]", i)
+		if r.Name != "" {
+			field += "(" + r.Name + ")"
+		}
+		if r.Name == "" {
+			fail(field+".name", "不能为空")
+		} else if names[r.Name] {
+			fail(field+".name", "名称 %q 重复", r.Name)
+		}
+		names[r.Name] = true
+		// 事件流挂载在HTTP服务的固定路径上
+		if r.Type == "sse" {
+			if streams++; streams > 1 {
+				fail(field+".type", "sse 转发器只能配置一个")
+			}
+		}
+		if r.Filter != nil {
+			if err := r.Filter.Compile(); err != nil {
+				fail(field+".filter", "%v", err)
+			}
+		}
+		var err error
+		if r.Spec, err = redirect.Parse(r.Type, r.Decode); err != nil {
+			fail(field, "%v", err)
+			continue
+		}
+		for _, port := range r.Spec.Ports() {
+			listen(field, port)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("配置错误:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Decode 严格解析转发器配置,未知的字段视为错误
+func (r Redirector) Decode(v any) error {
+	data, err := yaml.Marshal(r.Options)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(v)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		// 配置是重新序列化后解析的,行号没有意义
+		for i, e := range typeErr.Errors {
+			if _, msg, ok := strings.Cut(e, ": "); ok && strings.HasPrefix(e, "line ") {
+				typeErr.Errors[i] = msg
+			}
+		}
+		return errors.New(strings.Join(typeErr.Errors, "; "))
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// DSN mysql连接地址
+func (m MySQL) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s", m.Username, m.Password, m.Host, m.Port, m.Database, m.Parameters)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port < 65536
+}
+
+// Size 字节大小,支持 B、KB、MB、GB 单位,如 20MB,不带单位时为字节
+type Size int64
+
+func (s *Size) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = size
+	return nil
+}
+
+// ParseSize 解析字节大小
+func ParseSize(s string) (Size, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for i, suffix := range []string{"GB", "MB", "KB", "B"} {
+		if v, ok := strings.CutSuffix(value, suffix); ok {
+			value, unit = strings.TrimSpace(v), 1<<(10*(3-i))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("大小 %q 无效", s)
+	}
+	return Size(n * unit), nil
+}
@@ -0,0 +1,4 @@
+// Package grpcapi gRPC接口的protobuf定义及生成代码,其他语言的客户端可以从 hub.proto 生成
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative hub.proto
@@ -5,14 +5,17 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/eatmoreapple/openwechat"
 	"github.com/robfig/cron/v3"
 	"golang.org/x/time/rate"
+	"gorm.io/gorm"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	authManager "wechat-hub/auth"
 	"wechat-hub/hub"
@@ -28,9 +31,26 @@ type Hub struct {
 	sender    *MsgSender
 	auth      *authManager.Manager
 	limit     *rate.Limiter
-	redirects []redirect.MessageRedirector
+	outbox    hub.OutboxManager
+	redirects map[string]redirect.MessageRedirector
+	filters   map[string]*hub.Filter // 转发器的消息过滤规则
+
+	replayMu sync.Mutex
+	replayID int64
+	replays  map[int64]*ReplayJob
 }
 
+const (
+	outboxLease    = 30 * time.Second    // 投递中的消息在该时间内不会被重试
+	outboxInterval = 5 * time.Second     // 重试任务间隔
+	outboxBatch    = 100                 // 每次重试的最大条数
+	outboxKeep     = 7 * 24 * time.Hour  // 已确认的消息保留时间
+	outboxFailKeep = 30 * 24 * time.Hour // 失败的消息保留时间,期间可以手动重试
+
+	replayMax  = 10000     // 单个重放任务最多重放的条数
+	replayKeep = time.Hour // 结束的重放任务保留时间
+)
+
 func NewHub(ctx context.Context, member hub.MemberManager, message hub.MessageManager, storage storage.Storage, auth *authManager.Manager) *Hub {
 	return &Hub{
 		ctx:       ctx,
@@ -39,7 +59,9 @@ func NewHub(ctx context.Context, member hub.MemberManager, message hub.MessageMa
 		storage:   storage,
 		auth:      auth,
 		limit:     rate.NewLimiter(rate.Every(10*time.Second), 1),
-		redirects: []redirect.MessageRedirector{},
+		redirects: map[string]redirect.MessageRedirector{},
+		filters:   map[string]*hub.Filter{},
+		replays:   map[int64]*ReplayJob{},
 	}
 }
 
@@ -47,36 +69,40 @@ func (h *Hub) SetMessageSender(sender *MsgSender) {
 	h.sender = sender
 }
 
-// AddRedirect 添加一个转发器
-func (h *Hub) AddRedirect(redirect redirect.MessageRedirector) {
-	h.redirects = append(h.redirects, redirect)
+// SetOutbox 设置消息投递箱,设置后转发失败的消息会持久化并重试
+func (h *Hub) SetOutbox(outbox hub.OutboxManager) {
+	h.outbox = outbox
 }
 
-// UseWebsocketClientRedirect 使用websocket客户端转发
-func (h *Hub) UseWebsocketClientRedirect(serverUrl string, heartbeat time.Duration) {
-	h.AddRedirect(redirect.NewWebsocketClientMessageHandler(h.ctx, serverUrl, redirect.WSClientHeartbeat(heartbeat)))
-}
-
-// UseWebsocketServerRedirect 使用websocket服务端转发
-func (h *Hub) UseWebsocketServerRedirect(port int, heartbeat time.Duration) {
-	server := redirect.NewWebsocketServerMessageHandler(h.ctx, redirect.WSServerHeartbeat(heartbeat), redirect.WSServerAuth(h.auth))
-	server.OnMessage(h.receive)
-	go server.ListenAndServe(port)
-	h.AddRedirect(server)
+// AddRedirect 添加一个转发器,name用于投递记录及重试
+func (h *Hub) AddRedirect(name string, redirect redirect.MessageRedirector) {
+	if _, ok := h.redirects[name]; ok {
+		panic(fmt.Sprintf("duplicate redirect name: %s", name))
+	}
+	h.redirects[name] = redirect
 }
 
-func (h *Hub) UseMQTTServerRedirect(cacheDir string, tcpPort int, wsPort int) {
-	options := []redirect.MQTTOption{
-		redirect.WithSubscribeTopic("command"), redirect.WithMQTTAuth(h.auth),
-		redirect.WithTCP(tcpPort),
+// UseRedirect 按配置创建转发器,转发器收到的命令由hub处理,filter为空时转发所有消息
+func (h *Hub) UseRedirect(name string, spec *redirect.Spec, filter *hub.Filter, dataDir string, db *gorm.DB) error {
+	r, err := spec.New(&redirect.Env{
+		Ctx:     h.ctx,
+		Name:    name,
+		DataDir: dataDir,
+		DB:      db,
+		Auth:    h.auth,
+		OnMessage: func(message []byte, from string, identity *authManager.Identity) ([]byte, error) {
+			return h.receive(message, from, name, identity)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("创建转发器 %s 失败: %w", name, err)
 	}
-	if wsPort > 0 {
-		options = append(options, redirect.WithWS(wsPort))
+	h.AddRedirect(name, r)
+	if filter != nil {
+		h.filters[name] = filter
 	}
-	server := redirect.NewMQTTServerMessageHandler(cacheDir, "message", options...)
-	server.OnMessage(h.receive)
-	go server.ListenAndServe()
-	h.AddRedirect(server)
+	slog.Info("启用转发器", "name", name, "type", spec.Type)
+	return nil
 }
 
 // dispatch 用于将组装好的消息下发给转发器
@@ -92,47 +118,418 @@ func (h *Hub) dispatch(message hub.Message) {
 		slog.Error("消息序列化失败", "msgId", message.ID(), "err", err)
 		return
 	}
-	for _, r := range h.redirects {
-		go func(redirect redirect.MessageRedirector) {
-			if err := redirect.SendMessage(marshal); err != nil {
-				slog.Error("消息转发失败", "msgId", message.ID(), "err", err)
+	for name, r := range h.redirects {
+		if !h.filters[name].Match(message) {
+			continue
+		}
+		h.deliver(name, r, message.ID(), marshal)
+	}
+}
+
+// 重放任务状态
+const (
+	ReplayRunning   = "running"
+	ReplayDone      = "done"
+	ReplayFailed    = "failed"
+	ReplayCancelled = "cancelled"
+)
+
+// ReplayJob 后台执行的历史消息重放
+type ReplayJob struct {
+	ID         int64  `json:"id"`
+	Redirector string `json:"redirector"`
+	Client     string `json:"client,omitempty"`
+	Max        int    `json:"max"`               // 最多重放的条数
+	Count      int    `json:"count"`             // 已重放的条数
+	Limited    bool   `json:"limited,omitempty"` // 达到最大条数后停止,还有未重放的消息
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	StartTime  int64  `json:"startTime"`
+	EndTime    int64  `json:"endTime,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+var errReplayLimit = errors.New("达到最大重放条数")
+
+// StartReplay 在后台按时间顺序重放历史消息到转发器,client不为空时只发送给该客户端,
+// max为最多重放的条数,同一转发器同时只能有一个重放任务
+func (h *Hub) StartReplay(name string, client string, q *hub.MessageQuery, max int) (ReplayJob, error) {
+	r, ok := h.redirects[name]
+	if !ok {
+		return ReplayJob{}, fmt.Errorf("转发器 %s 不存在", name)
+	}
+	send := r.SendMessage
+	if client != "" {
+		cr, ok := r.(redirect.ClientRedirector)
+		if !ok {
+			return ReplayJob{}, fmt.Errorf("转发器 %s 不支持指定客户端", name)
+		}
+		send = func(bytes []byte) error {
+			return cr.SendTo(client, bytes)
+		}
+	}
+	if max <= 0 || max > replayMax {
+		max = replayMax
+	}
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+	for id, job := range h.replays {
+		if job.Status == ReplayRunning && job.Redirector == name {
+			return ReplayJob{}, fmt.Errorf("转发器 %s 正在执行重放任务 %d", name, job.ID)
+		}
+		// 清理结束较久的任务
+		if job.Status != ReplayRunning && time.Since(time.UnixMilli(job.EndTime)) > replayKeep {
+			delete(h.replays, id)
+		}
+	}
+	ctx, cancel := context.WithCancel(h.ctx)
+	h.replayID++
+	job := &ReplayJob{
+		ID:         h.replayID,
+		Redirector: name,
+		Client:     client,
+		Max:        max,
+		Status:     ReplayRunning,
+		StartTime:  time.Now().UnixMilli(),
+		cancel:     cancel,
+	}
+	h.replays[job.ID] = job
+	go h.replay(ctx, job, send, q)
+	return *job, nil
+}
+
+// ReplayJob 查询重放任务
+func (h *Hub) ReplayJob(id int64) (ReplayJob, bool) {
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+	job, ok := h.replays[id]
+	if !ok {
+		return ReplayJob{}, false
+	}
+	return *job, true
+}
+
+// CancelReplay 取消执行中的重放任务
+func (h *Hub) CancelReplay(id int64) error {
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+	job, ok := h.replays[id]
+	if !ok || job.Status != ReplayRunning {
+		return errors.New("重放任务不存在或已结束")
+	}
+	job.cancel()
+	return nil
+}
+
+func (h *Hub) replay(ctx context.Context, job *ReplayJob, send func([]byte) error, q *hub.MessageQuery) {
+	defer job.cancel()
+	count := 0
+	err := h.message.Each(q, func(stored hub.StoredMessage) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if count >= job.Max {
+			return errReplayLimit
+		}
+		message, err := stored.Restore()
+		if err != nil {
+			slog.Error("还原历史消息失败", "msgId", stored.ID, "err", err)
+			return nil
+		}
+		if !h.filters[job.Redirector].Match(message) {
+			return nil
+		}
+		message.SetReplay()
+		marshal, err := message.Marshal()
+		if err != nil {
+			return err
+		}
+		if err = send(marshal); err != nil {
+			return err
+		}
+		count++
+		h.replayMu.Lock()
+		job.Count = count
+		h.replayMu.Unlock()
+		return nil
+	})
+	slog.Info("重放历史消息", "id", job.ID, "redirect", job.Redirector, "client", job.Client, "count", count, "err", err)
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+	job.EndTime = time.Now().UnixMilli()
+	switch {
+	case err == nil:
+		job.Status = ReplayDone
+	case errors.Is(err, errReplayLimit):
+		job.Status, job.Limited = ReplayDone, true
+	case errors.Is(err, context.Canceled):
+		job.Status = ReplayCancelled
+	default:
+		job.Status, job.Error = ReplayFailed, err.Error()
+	}
+}
+
+func (h *Hub) botStatus() (*hub.BotStatus, error) {
+	status := &hub.BotStatus{Alive: h.sender.Bot.Alive()}
+	if !status.Alive {
+		return status, nil
+	}
+	self, err := h.sender.Bot.GetCurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	status.Nickname = self.NickName
+	if status.UID, err = h.member.GetID(self.User); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// PublishState 发布机器人状态及群列表到支持保存状态的转发器
+func (h *Hub) PublishState() {
+	states := map[string]any{}
+	if status, err := h.botStatus(); err != nil {
+		slog.Error("获取机器人状态失败", "err", err)
+	} else {
+		states["bot"] = status
+		if status.Alive {
+			if groups, err := h.member.GetGroups(); err != nil {
+				slog.Error("获取群列表失败", "err", err)
+			} else {
+				states["groups"] = groups
+			}
+		}
+	}
+	for name, r := range h.redirects {
+		sr, ok := r.(redirect.StateRedirector)
+		if !ok {
+			continue
+		}
+		for state, value := range states {
+			payload, err := json.Marshal(value)
+			if err != nil {
+				slog.Error("状态序列化失败", "state", state, "err", err)
+				continue
+			}
+			if err = sr.PublishState(state, payload); err != nil {
+				slog.Error("发布状态失败", "redirect", name, "state", state, "err", err)
+			}
+		}
+	}
+}
+
+// Clients 获取转发器的在线客户端
+func (h *Hub) Clients(name string) ([]redirect.ClientInfo, error) {
+	r, ok := h.redirects[name]
+	if !ok {
+		return nil, fmt.Errorf("转发器 %s 不存在", name)
+	}
+	cr, ok := r.(redirect.ClientRedirector)
+	if !ok {
+		return nil, fmt.Errorf("转发器 %s 不支持查询客户端", name)
+	}
+	return cr.Clients(), nil
+}
+
+// deliver 投递消息到转发器,启用投递箱时先落库再发送,有多个投递目标的转发器每个目标单独记录
+func (h *Hub) deliver(name string, r redirect.MessageRedirector, msgID string, payload []byte) {
+	targets := []string{""}
+	if tr, ok := r.(redirect.TargetRedirector); ok && h.outbox != nil {
+		targets = tr.Targets()
+	}
+	// 尽力投递的转发器不记录投递箱
+	outbox := h.outbox != nil
+	if br, ok := r.(redirect.BestEffortRedirector); ok && br.BestEffort() {
+		outbox = false
+	}
+	for _, target := range targets {
+		var entry *hub.Outbox
+		if outbox {
+			var err error
+			if entry, err = h.outbox.Add(name, target, msgID, payload, outboxLease); err != nil {
+				slog.Error("消息记录投递箱失败", "redirect", name, "target", target, "msgId", msgID, "err", err)
+			}
+		}
+		go func() {
+			var err error
+			if entry != nil {
+				err = h.sendOutbox(r, entry)
+			} else {
+				err = sendTarget(r, target, payload)
+			}
+			if err != nil {
+				slog.Error("消息转发失败", "redirect", name, "target", target, "msgId", msgID, "err", err)
+			}
+		}()
+	}
+}
+
+// sendOutbox 发送投递箱中的消息并更新投递状态,异步写入的转发器在实际写入后才确认,
+// 超过租期仍未确认的消息由重试任务重新投递
+func (h *Hub) sendOutbox(r redirect.MessageRedirector, entry *hub.Outbox) error {
+	ar, ok := r.(redirect.AckRedirector)
+	if !ok || entry.Target != "" {
+		err := sendTarget(r, entry.Target, entry.Payload)
+		h.ackOutbox(entry, err)
+		return err
+	}
+	err := ar.SendMessageAck(entry.Payload, func(err error) {
+		if err != nil {
+			slog.Warn("消息写入失败", "redirect", entry.Redirector, "msgId", entry.MsgID, "err", err)
+		}
+		go h.ackOutbox(entry, err)
+	})
+	if err != nil {
+		h.ackOutbox(entry, err)
+	}
+	return err
+}
+
+// sendTarget target不为空时只发送到转发器的该目标
+func sendTarget(r redirect.MessageRedirector, target string, payload []byte) error {
+	if tr, ok := r.(redirect.TargetRedirector); ok && target != "" {
+		return tr.SendTarget(target, payload)
+	}
+	return r.SendMessage(payload)
+}
+
+func (h *Hub) ackOutbox(entry *hub.Outbox, err error) {
+	if err == nil {
+		err = h.outbox.Ack(entry.ID)
+	} else {
+		err = h.outbox.Fail(entry.ID, err)
+	}
+	if err != nil {
+		slog.Error("更新投递状态失败", "redirect", entry.Redirector, "id", entry.ID, "err", err)
+	}
+}
+
+// StartOutbox 开始重试投递箱中未确认的消息
+func (h *Hub) StartOutbox() {
+	if h.outbox == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(outboxInterval)
+		defer ticker.Stop()
+		lastClean := time.Time{}
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case now := <-ticker.C:
+				h.retryOutbox()
+				if now.Sub(lastClean) > time.Hour {
+					lastClean = now
+					if n, err := h.outbox.Clean(now.Add(-outboxKeep), now.Add(-outboxFailKeep)); err != nil {
+						slog.Error("清理投递箱失败", "err", err)
+					} else if n > 0 {
+						slog.Info("清理投递箱", "count", n)
+					}
+				}
 			}
-		}(r)
+		}
+	}()
+}
+
+func (h *Hub) retryOutbox() {
+	entries, err := h.outbox.Due(outboxBatch, outboxLease)
+	if err != nil {
+		slog.Error("获取待重试消息失败", "err", err)
+	}
+	for i := range entries {
+		entry := &entries[i]
+		r, ok := h.redirects[entry.Redirector]
+		if !ok {
+			h.ackOutbox(entry, errors.New("转发器不存在"))
+			continue
+		}
+		if err := h.sendOutbox(r, entry); err != nil {
+			slog.Warn("消息重试转发失败", "redirect", entry.Redirector, "target", entry.Target, "msgId", entry.MsgID, "attempts", entry.Attempts+1, "err", err)
+		}
 	}
 }
 
-// 接受转发器上报的消息
-func (h *Hub) receive(message []byte, from string, id string) (err error) {
+var (
+	errBadCommand = errors.New("命令错误")
+	errForbidden  = errors.New("没有权限")
+)
+
+// 接受转发器上报的消息,命令携带id时返回执行结果
+// receive 处理转发器收到的命令,from为转发器类型,name为转发器名称
+func (h *Hub) receive(message []byte, from, name string, identity *authManager.Identity) (reply []byte, err error) {
+	command := &hub.Command{}
+	var data any
+	var user string
+	if identity != nil {
+		user = identity.Username
+	}
 	defer func() {
 		if e := recover(); e != nil {
-			slog.Error("处理上报消息出错", "receiver", from, "id", id, "Error", e)
+			slog.Error("处理上报消息出错", "receiver", from, "user", user, "Error", e)
 			err = fmt.Errorf("panic: %v", e)
 		}
+		if command.ID != "" {
+			reply = h.commandResult(command, data, err)
+		}
 	}()
 	if h.sender == nil {
-		slog.Debug("收到上报消息", "message", string(message), "receiver", from, "id", id)
+		slog.Debug("收到上报消息", "message", string(message), "receiver", from, "user", user)
 		return
 	}
-	command := &hub.Command{}
 	if err = json.Unmarshal(message, command); err != nil {
-		slog.Error("命令消息解析失败", "message", string(message), "receiver", from, "id", id, "err", err)
+		slog.Error("命令消息解析失败", "message", string(message), "receiver", from, "user", user, "err", err)
+		return nil, fmt.Errorf("%w: %w", errBadCommand, err)
+	}
+	handler, ok := commandHandlers[command.Command]
+	if !ok {
+		slog.Error("不支持的命令", "command", command.Command, "param", string(command.Param), "receiver", from, "user", user)
+		err = fmt.Errorf("%w: 不支持的命令 %s", errBadCommand, command.Command)
 		return
 	}
-	if command.Command != "sendMessage" {
-		slog.Error("不支持的命令", "command", command.Command, "param", command.Param, "receiver", from, "id", id)
+	if scope := commandScopes[command.Command]; scope != "" && !identity.Allow(scope) {
+		slog.Warn("命令没有权限", "command", command.Command, "scope", scope, "receiver", from, "user", user)
+		err = fmt.Errorf("%w: 需要 %s 权限", errForbidden, scope)
 		return
 	}
-	err = h.sender.SendMsg(&command.Param)
-	if err != nil {
-		slog.Error("消息发送失败", "receiver", from, "id", id, "err", err)
+	if data, err = handler(h, identity, newActor(identity, from, name), command.Param); err != nil {
+		slog.Error("命令执行失败", "command", command.Command, "receiver", from, "user", user, "err", err)
 	}
 	return
 }
 
+// commandResult 组装命令执行结果
+func (h *Hub) commandResult(command *hub.Command, data any, err error) []byte {
+	result := hub.CommandResult{
+		ID:      command.ID,
+		Command: command.Command,
+		Msg:     "OK",
+		Data:    data,
+	}
+	if errors.Is(err, errBadCommand) {
+		result.Code = http.StatusBadRequest
+		result.Msg = err.Error()
+	} else if errors.Is(err, errForbidden) {
+		result.Code = http.StatusForbidden
+		result.Msg = err.Error()
+	} else if err != nil {
+		result.Code = http.StatusInternalServerError
+		result.Msg = err.Error()
+	}
+	bytes, e := json.Marshal(result)
+	if e != nil {
+		slog.Error("命令结果序列化失败", "id", command.ID, "err", e)
+		return nil
+	}
+	return bytes
+}
+
 // Register 注册消息监听器
 func (h *Hub) Register(dispatcher *openwechat.MessageMatchDispatcher) {
 	dispatcher.RegisterHandler(h.messageFilter())
 	dispatcher.OnGroup(h.onSystemMessage, h.onMedia)
+	dispatcher.OnFriend(h.onMedia)
 	dispatcher.OnText(h.onText)
 	dispatcher.OnRecalled(h.onRecalled)
 }
@@ -153,7 +550,9 @@ func (h *Hub) messageFilter() (matchFunc openwechat.MatchFunc, handlers openwech
 
 func (h *Hub) prepareMessage(ctx *openwechat.MessageContext) *hub.BaseMessage {
 	var gid, groupName, uid, username string
+	chatType := hub.ChatPrivate
 	if ctx.IsSendByGroup() {
+		chatType = hub.ChatGroup
 		g, _ := ctx.Sender()
 		if id, err := h.member.GetID(g); err != nil {
 			slog.Error("获取群组ID失败", "msgId", ctx.MsgId, "err", err)
@@ -184,6 +583,7 @@ func (h *Hub) prepareMessage(ctx *openwechat.MessageContext) *hub.BaseMessage {
 		MsgType:   int(ctx.MsgType),
 		Time:      ctx.CreateTime,
 		MsgID:     ctx.MsgId,
+		ChatType:  chatType,
 		GID:       gid,
 		GroupName: groupName,
 		UID:       uid,
@@ -193,7 +593,9 @@ func (h *Hub) prepareMessage(ctx *openwechat.MessageContext) *hub.BaseMessage {
 
 func (h *Hub) prepareSystemMessage(ctx *openwechat.MessageContext) *hub.SystemMessage {
 	var gid, groupName, uid, username string
+	chatType := hub.ChatPrivate
 	if ctx.IsSendByGroup() {
+		chatType = hub.ChatGroup
 		g, _ := ctx.Sender()
 		if id, err := h.member.GetID(g); err != nil {
 			slog.Error("获取群组ID失败", "msgId", ctx.MsgId, "err", err)
@@ -217,6 +619,7 @@ func (h *Hub) prepareSystemMessage(ctx *openwechat.MessageContext) *hub.SystemMe
 			MsgType:   int(openwechat.MsgTypeSys),
 			Time:      ctx.CreateTime,
 			MsgID:     ctx.MsgId,
+			ChatType:  chatType,
 			GID:       gid,
 			GroupName: groupName,
 			UID:       uid,
@@ -467,9 +870,10 @@ func (h *Hub) onMedia(ctx *openwechat.MessageContext) {
 	}
 }
 
-func (h *Hub) StartWatchMembers() {
+// StartWatchMembers 按cron表达式定时刷新群成员,表达式包含秒
+func (h *Hub) StartWatchMembers(specs []string) {
 	c := cron.New(cron.WithSeconds(), cron.WithLogger(cron.DefaultLogger))
-	for _, spec := range []string{"0 0/5 6-23 * * *", "0 0/30 0-6 * * *"} {
+	for _, spec := range specs {
 		_, err := c.AddFunc(spec, h.watchMembersAndNotify)
 		if err != nil {
 			slog.Error("添加定时任务出错", "cron", spec, "error", err)
@@ -480,6 +884,7 @@ func (h *Hub) StartWatchMembers() {
 }
 
 func (h *Hub) watchMembersAndNotify() {
+	defer h.PublishState()
 	exitGroupUserMap := h.member.RefreshGroupMember()
 	if len(exitGroupUserMap) == 0 {
 		return
@@ -501,6 +906,7 @@ func (h *Hub) watchMembersAndNotify() {
 			BaseMessage: hub.BaseMessage{
 				MsgType:   int(openwechat.MsgTypeSys),
 				Time:      time.Now().Unix(),
+				ChatType:  hub.ChatGroup,
 				GID:       gid,
 				GroupName: groupName,
 			},
@@ -0,0 +1,138 @@
+package hub
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditSend   = "send"   // 发送消息
+	AuditRevoke = "revoke" // 撤回消息
+
+	auditSummaryLength = 100
+	auditErrorLength   = 1000 // 截断后加省略号不超过字段长度
+)
+
+type (
+	AuditManager interface {
+		// Record 记录一条操作
+		Record(audit *Audit) error
+		// List 分页查询操作记录,按时间倒序
+		List(q *AuditQuery, offset, limit int) ([]Audit, int64, error)
+	}
+
+	// Actor 操作者,Channel为操作来源 HTTP、WS_SERVER、WS_CLIENT、MQTT、GRPC、NATS,
+	// 同一类型可以配置多个转发器,Redirector为转发器名称
+	Actor struct {
+		Username   string // 认证用户,未开启认证时为空
+		TokenID    int    // 使用令牌认证时的令牌id
+		Channel    string
+		Redirector string // HTTP接口为空
+	}
+
+	// Audit 出站操作的审计记录
+	Audit struct {
+		ID         int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+		Username   string `gorm:"type:varchar(100);index" json:"username"`
+		TokenID    int    `gorm:"" json:"tokenId,omitempty"`
+		Channel    string `gorm:"type:varchar(20)" json:"channel"`
+		Redirector string `gorm:"type:varchar(100)" json:"redirector,omitempty"`
+		Action     string `gorm:"type:varchar(20)" json:"action"`
+		Gid        string `gorm:"column:gid;type:varchar(40);index" json:"gid,omitempty"`
+		Uid        string `gorm:"column:uid;type:varchar(40)" json:"uid,omitempty"`
+		MsgType    int    `gorm:"" json:"msgType,omitempty"`
+		Summary    string `gorm:"type:varchar(255)" json:"summary"`            // 消息内容摘要
+		MsgID      string `gorm:"column:msg_id;type:varchar(50)" json:"msgId"` // 发送成功的消息id或撤回的消息id
+		Success    bool   `gorm:"" json:"success"`
+		Error      string `gorm:"type:varchar(1024)" json:"error,omitempty"`
+		Time       int64  `gorm:"autoCreateTime:milli;index" json:"time"`
+	}
+
+	// AuditQuery 操作记录查询条件,为空的条件不参与查询
+	AuditQuery struct {
+		Username   string
+		Channel    string
+		Redirector string
+		Action     string
+		Gid        string
+		Start      int64 // 开始时间(毫秒)
+		End        int64 // 结束时间(毫秒)
+	}
+
+	dbAuditManager struct {
+		db *gorm.DB
+	}
+)
+
+func NewAuditManager(db *gorm.DB) AuditManager {
+	if err := db.AutoMigrate(Audit{}); err != nil {
+		panic(err)
+	}
+	return &dbAuditManager{db: db}
+}
+
+func (m *dbAuditManager) Record(audit *Audit) error {
+	audit.Summary = truncate(audit.Summary, auditSummaryLength)
+	audit.Error = truncate(audit.Error, auditErrorLength)
+	return m.db.Create(audit).Error
+}
+
+func (m *dbAuditManager) List(q *AuditQuery, offset, limit int) ([]Audit, int64, error) {
+	db := m.db.Model(&Audit{})
+	if q.Username != "" {
+		db = db.Where("username = ?", q.Username)
+	}
+	if q.Channel != "" {
+		db = db.Where("channel = ?", q.Channel)
+	}
+	if q.Redirector != "" {
+		db = db.Where("redirector = ?", q.Redirector)
+	}
+	if q.Action != "" {
+		db = db.Where("action = ?", q.Action)
+	}
+	if q.Gid != "" {
+		db = db.Where("gid = ?", q.Gid)
+	}
+	if q.Start > 0 {
+		db = db.Where("time >= ?", q.Start)
+	}
+	if q.End > 0 {
+		db = db.Where("time <= ?", q.End)
+	}
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var audits []Audit
+	if err := db.Order("id desc").Offset(offset).Limit(limit).Find(&audits).Error; err != nil {
+		return nil, 0, err
+	}
+	return audits, total, nil
+}
+
+// Summary 消息内容摘要,内嵌的BASE64资源只记录大小
+func (c *SendMsgCommand) Summary() string {
+	if c.Type == 1 {
+		return c.Body
+	}
+	body := c.Body
+	if data, ok := strings.CutPrefix(body, "BASE64:"); ok {
+		body = fmt.Sprintf("BASE64(%d字节)", len(data)*3/4)
+	}
+	if c.Filename != "" {
+		return c.Filename + " " + body
+	}
+	return body
+}
+
+// truncate 按字符截断字符串
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
@@ -1,16 +1,155 @@
 package hub
 
+import (
+	"encoding/json"
+	"errors"
+)
+
+const (
+	CommandSendMessage      = "sendMessage"      // 发送消息
+	CommandRevokeMessage    = "revokeMessage"    // 撤回消息
+	CommandListGroups       = "listGroups"       // 群列表
+	CommandListGroupMembers = "listGroupMembers" // 群成员列表
+	CommandQueryMessages    = "queryMessages"    // 历史消息
+	CommandBotStatus        = "botStatus"        // 登录状态
+	CommandRefreshMembers   = "refreshMembers"   // 刷新群成员
+
+	// websocket连接的订阅及断线续传命令,由转发器处理
+	CommandSubscribe     = "subscribe"     // 订阅消息
+	CommandUnsubscribe   = "unsubscribe"   // 取消订阅
+	CommandSubscriptions = "subscriptions" // 当前订阅
+	CommandAck           = "ack"           // 确认已收到的消息序号
+	CommandResume        = "resume"        // 从指定序号之后继续接收消息
+)
+
 type (
 	Command struct {
-		Command string         `json:"command"` // SendMsg:发送消息
-		Param   SendMsgCommand `json:"param"`
+		ID      string          `json:"id,omitempty"` // 请求id,不为空时回复执行结果
+		Command string          `json:"command"`      // 命令名称
+		Param   json.RawMessage `json:"param,omitempty"`
+	}
+
+	// CommandParam 命令参数
+	CommandParam interface {
+		Validate() error
+	}
+
+	// CommandResult 命令执行结果
+	CommandResult struct {
+		ID      string `json:"id"`      // 请求id
+		Command string `json:"command"` // 命令
+		Code    int    `json:"code"`    // 0表示成功
+		Msg     string `json:"msg"`     //
+		Data    any    `json:"data,omitempty"`
 	}
 
 	SendMsgCommand struct {
 		Gid      string `json:"gid" form:"gid"`           // 群id
+		Uid      string `json:"uid" form:"uid"`           // 好友id,私聊时使用,与gid二选一
 		Type     int    `json:"type" form:"type"`         // 回复类型 1:文本,2:图片,3:视频,4:文件
 		Body     string `json:"body" form:"body"`         // 回复内容,type=1时为文本内容,type=2/3/4时为资源地址
 		Filename string `json:"filename" form:"filename"` // 文件名称
 		Prompt   string `json:"prompt" form:"prompt"`     // 回复提示
 	}
+
+	SendMsgResult struct {
+		MsgID string `json:"msgId"` // 发送成功的消息id
+	}
+
+	RevokeMsgCommand struct {
+		MsgID string `json:"msgId"` // 发送成功时返回的消息id
+	}
+
+	GroupMembersCommand struct {
+		Gid string `json:"gid"` // 群id
+	}
+
+	// SubscribeCommand 订阅条件,设置的条件需要同时满足,同一条件的多个值满足其一即可
+	SubscribeCommand struct {
+		Groups []string `json:"groups,omitempty"` // 群id
+		Types  []int    `json:"types,omitempty"`  // 消息类型
+		Events []string `json:"events,omitempty"` // 系统消息事件,设置后只接收系统消息
+	}
+
+	UnsubscribeCommand struct {
+		ID string `json:"id"` // 订阅id,为空时取消所有订阅
+	}
+
+	// AckCommand 确认序号及之前的消息已处理,断线重连后从该序号之后继续
+	AckCommand struct {
+		Seq int64 `json:"seq"`
+	}
+
+	// ResumeCommand 补发序号之后的积压消息,然后继续接收实时消息
+	ResumeCommand struct {
+		Seq int64 `json:"seq"` // 为0时从该客户端最后确认的序号继续
+	}
+
+	// ResumeResult 实际补发的起始序号,请求的序号之后有消息已过期清理时gap为true
+	ResumeResult struct {
+		From   int64 `json:"from"`             // 从该序号之后补发,超过最新序号时为最新序号
+		Gap    bool  `json:"gap,omitempty"`    // 部分消息已超过保留时间,无法补发
+		Oldest int64 `json:"oldest,omitempty"` // gap为true时保留的最早序号
+	}
+
+	BotStatus struct {
+		Alive    bool   `json:"alive"`              // 是否在线
+		UID      string `json:"uid,omitempty"`      // 机器人id
+		Nickname string `json:"nickname,omitempty"` // 机器人昵称
+	}
 )
+
+func (c *SendMsgCommand) Validate() error {
+	if c.Gid == "" && c.Uid == "" {
+		return errors.New("群ID和好友ID不能同时为空")
+	}
+	if c.Gid != "" && c.Uid != "" {
+		return errors.New("群ID和好友ID只能指定一个")
+	}
+	if c.Body == "" {
+		return errors.New("消息不能为空")
+	}
+	if c.Type < 1 || c.Type > 4 {
+		return errors.New("暂不支持该类型消息")
+	}
+	return nil
+}
+
+func (c *RevokeMsgCommand) Validate() error {
+	if c.MsgID == "" {
+		return errors.New("消息ID不能为空")
+	}
+	return nil
+}
+
+func (c *SubscribeCommand) Validate() error {
+	if len(c.Groups) == 0 && len(c.Types) == 0 && len(c.Events) == 0 {
+		return errors.New("订阅条件不能为空")
+	}
+	return nil
+}
+
+func (c *UnsubscribeCommand) Validate() error {
+	return nil
+}
+
+func (c *AckCommand) Validate() error {
+	if c.Seq <= 0 {
+		return errors.New("序号无效")
+	}
+	return nil
+}
+
+func (c *ResumeCommand) Validate() error {
+	if c.Seq < 0 {
+		return errors.New("序号无效")
+	}
+	return nil
+}
+
+func (c *GroupMembersCommand) Validate() error {
+	if c.Gid == "" {
+		return errors.New("群ID不能为空")
+	}
+	return nil
+}
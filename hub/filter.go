@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+type (
+	// Filter 消息过滤规则,匹配include中任一规则且不匹配exclude中任何规则的消息才会转发,include为空时匹配所有消息
+	Filter struct {
+		Include []Rule `yaml:"include" json:"include,omitempty"`
+		Exclude []Rule `yaml:"exclude" json:"exclude,omitempty"`
+	}
+
+	// Rule 匹配规则,设置的条件需要同时满足,同一条件的多个值满足其一即可
+	Rule struct {
+		Groups  []string `yaml:"groups" json:"groups,omitempty"`   // 群id
+		Types   []int    `yaml:"types" json:"types,omitempty"`     // 消息类型
+		Events  []string `yaml:"events" json:"events,omitempty"`   // 系统消息事件,设置后只匹配系统消息
+		Senders []string `yaml:"senders" json:"senders,omitempty"` // 发送者id
+		AtBot   *bool    `yaml:"atBot" json:"atBot,omitempty"`     // 是否@了机器人
+		Content string   `yaml:"content" json:"content,omitempty"` // 内容正则,文本消息为内容,文件消息为文件名
+
+		content *regexp.Regexp
+	}
+)
+
+// Compile 校验并编译规则,使用前需要调用
+func (f *Filter) Compile() error {
+	for i := range f.Include {
+		if err := f.Include[i].compile(); err != nil {
+			return fmt.Errorf("include[%d]: %w", i, err)
+		}
+	}
+	for i := range f.Exclude {
+		if err := f.Exclude[i].compile(); err != nil {
+			return fmt.Errorf("exclude[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Match 消息是否需要转发,filter为nil时匹配所有消息
+func (f *Filter) Match(message Message) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Include) > 0 && !slices.ContainsFunc(f.Include, func(r Rule) bool { return r.Match(message) }) {
+		return false
+	}
+	return !slices.ContainsFunc(f.Exclude, func(r Rule) bool { return r.Match(message) })
+}
+
+func (r *Rule) compile() error {
+	if r.Content == "" {
+		return nil
+	}
+	content, err := regexp.Compile(r.Content)
+	if err != nil {
+		return fmt.Errorf("content: 正则 %q 无效: %w", r.Content, err)
+	}
+	r.content = content
+	return nil
+}
+
+// Match 消息是否满足规则的所有条件
+func (r *Rule) Match(message Message) bool {
+	if len(r.Groups) > 0 {
+		if gid, _ := message.Group(); !slices.Contains(r.Groups, gid) {
+			return false
+		}
+	}
+	if len(r.Types) > 0 && !slices.Contains(r.Types, message.Type()) {
+		return false
+	}
+	if len(r.Events) > 0 {
+		system, ok := message.(*SystemMessage)
+		if !ok || !slices.Contains(r.Events, system.Event) {
+			return false
+		}
+	}
+	if len(r.Senders) > 0 {
+		if uid, _ := message.User(); !slices.Contains(r.Senders, uid) {
+			return false
+		}
+	}
+	if r.AtBot != nil && *r.AtBot != atBot(message) {
+		return false
+	}
+	if r.content != nil && !r.content.MatchString(content(message)) {
+		return false
+	}
+	return true
+}
+
+// atBot 是否为@机器人的文本消息
+func atBot(message Message) bool {
+	text, ok := message.(*TextMessage)
+	return ok && text.At != nil && text.At.Bot
+}
+
+// content 用于正则匹配的消息内容
+func content(message Message) string {
+	switch m := message.(type) {
+	case *TextMessage:
+		return m.Content
+	case *MediaMessage:
+		return m.Media.Filename
+	case *RevokedMessage:
+		return m.Revoke.ReplaceMsg
+	}
+	return ""
+}
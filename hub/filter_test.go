@@ -0,0 +1,54 @@
+package hub
+
+import "testing"
+
+func TestFilterMatch(t *testing.T) {
+	yes, no := true, false
+	text := &TextMessage{BaseMessage: BaseMessage{MsgType: 1, GID: "g1", UID: "u1"}, Content: "/ping now"}
+	at := &TextMessage{BaseMessage: BaseMessage{MsgType: 1, GID: "g2", UID: "u2"}, Content: "hi", At: &At{Bot: true}}
+	media := &MediaMessage{BaseMessage: BaseMessage{MsgType: 3, GID: "g1", UID: "u2"}, Media: Media{Filename: "report.pdf"}}
+	system := &SystemMessage{BaseMessage: BaseMessage{MsgType: 10000, GID: "g1"}, Event: "ExitGroup"}
+	cases := []struct {
+		name    string
+		filter  *Filter
+		message Message
+		expect  bool
+	}{
+		{"nil", nil, text, true},
+		{"empty", &Filter{}, text, true},
+		{"group", &Filter{Include: []Rule{{Groups: []string{"g1"}}}}, text, true},
+		{"other group", &Filter{Include: []Rule{{Groups: []string{"g2"}}}}, text, false},
+		{"any include", &Filter{Include: []Rule{{Groups: []string{"g2"}}, {Types: []int{1}}}}, text, true},
+		{"all conditions", &Filter{Include: []Rule{{Groups: []string{"g1"}, Senders: []string{"u2"}}}}, text, false},
+		{"type", &Filter{Include: []Rule{{Types: []int{3}}}}, media, true},
+		{"event", &Filter{Include: []Rule{{Events: []string{"ExitGroup"}}}}, system, true},
+		{"event not system", &Filter{Include: []Rule{{Events: []string{"ExitGroup"}}}}, text, false},
+		{"at bot", &Filter{Include: []Rule{{AtBot: &yes}}}, at, true},
+		{"not at bot", &Filter{Include: []Rule{{AtBot: &no}}}, at, false},
+		{"at bot media", &Filter{Include: []Rule{{AtBot: &yes}}}, media, false},
+		{"content", &Filter{Include: []Rule{{Content: "^/ping"}}}, text, true},
+		{"content filename", &Filter{Include: []Rule{{Content: `\.pdf$`}}}, media, true},
+		{"content system", &Filter{Include: []Rule{{Content: ".*"}}}, system, true},
+		{"content mismatch", &Filter{Include: []Rule{{Content: "^x"}}}, system, false},
+		{"exclude", &Filter{Exclude: []Rule{{Content: "^/ping"}}}, text, false},
+		{"exclude other", &Filter{Exclude: []Rule{{Groups: []string{"g2"}}}}, text, true},
+		{"include and exclude", &Filter{Include: []Rule{{Groups: []string{"g1"}}}, Exclude: []Rule{{Types: []int{3}}}}, media, false},
+	}
+	for _, c := range cases {
+		if c.filter != nil {
+			if err := c.filter.Compile(); err != nil {
+				t.Fatalf("%s: Compile() = %v", c.name, err)
+			}
+		}
+		if match := c.filter.Match(c.message); match != c.expect {
+			t.Errorf("%s: Match() = %v, want %v", c.name, match, c.expect)
+		}
+	}
+}
+
+func TestFilterCompile(t *testing.T) {
+	filter := &Filter{Exclude: []Rule{{}, {Content: "("}}}
+	if err := filter.Compile(); err == nil {
+		t.Fatal("Compile() accepted an invalid pattern")
+	}
+}
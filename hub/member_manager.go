@@ -25,6 +25,7 @@ type (
 		GetID(user *openwechat.User) (string, error)
 		GetName(id string) (string, error)
 		GetByID(id string) (string, error)
+		GetGroups() ([]Group, error)
 	}
 
 	GroupMemberManager interface {
@@ -51,6 +52,12 @@ type (
 		UpdateTime int64  `gorm:"autoCreateTime:milli;autoUpdateTime:milli"`
 	}
 
+	Group struct {
+		GID         string `json:"gid"`         // 群id
+		Name        string `json:"name"`        // 群名称
+		MemberCount int    `json:"memberCount"` // 群成员数
+	}
+
 	GroupUser struct {
 		GID        string `gorm:"primaryKey;column:gid;type:varchar(40)" json:"gid"`  // 群id
 		UID        string `gorm:"primaryKey;column:uid;type:varchar(40)" json:"uid"`  // 用户id
@@ -208,6 +215,32 @@ func (m *dBMemberManger) GetByID(id string) (string, error) {
 	}
 }
 
+// GetGroups 获取当前所有群
+func (m *dBMemberManger) GetGroups() ([]Group, error) {
+	self, err := m.bot.GetCurrentUser()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := self.Groups()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Group, 0, len(groups))
+	for _, group := range groups {
+		gid, err := m.GetID(group.User)
+		if err != nil {
+			slog.Error("获取群id失败", "error", err)
+			continue
+		}
+		result = append(result, Group{
+			GID:         gid,
+			Name:        group.NickName,
+			MemberCount: group.MemberList.Count(),
+		})
+	}
+	return result, nil
+}
+
 // GetGroupUsers 获取群成员
 func (m *dBMemberManger) GetGroupUsers(gid string) (map[string]GroupUser, error) {
 	var users []GroupUser
@@ -4,15 +4,22 @@ import (
 	"encoding/json"
 )
 
+const (
+	ChatGroup   = "group"   // 群聊
+	ChatPrivate = "private" // 私聊
+)
+
 type (
 	Message interface {
 		ID() string
+		Chat() string
 		Group() (string, string)
 		User() (string, string)
 		Type() int
 		MsgTime() int64
 		Message() string
 		Marshal() ([]byte, error)
+		SetReplay()
 	}
 
 	// BaseMessage 基础公共消息
@@ -20,10 +27,12 @@ type (
 		MsgType   int    `json:"msgType"`
 		Time      int64  `json:"time"`
 		MsgID     string `json:"msgID"`
+		ChatType  string `json:"chatType"` // 会话类型 group:群聊,private:私聊
 		GID       string `json:"gid,omitempty"`
 		GroupName string `json:"groupName,omitempty"`
 		UID       string `json:"uid,omitempty"`
 		Username  string `json:"username,omitempty"`
+		Replay    bool   `json:"replay,omitempty"` // 是否为重放的历史消息
 	}
 )
 
@@ -31,6 +40,15 @@ func (m *BaseMessage) ID() string {
 	return m.MsgID
 }
 
+// SetReplay 标记为重放的历史消息
+func (m *BaseMessage) SetReplay() {
+	m.Replay = true
+}
+
+func (m *BaseMessage) Chat() string {
+	return m.ChatType
+}
+
 func (m *BaseMessage) Group() (string, string) {
 	return m.GID, m.GroupName
 }
@@ -0,0 +1,220 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"wechat-hub/pkg/segment"
+
+	"gorm.io/gorm"
+)
+
+const (
+	highlightPre  = "<em>"
+	highlightPost = "</em>"
+	backfillBatch = 500
+)
+
+type (
+	// messageIndex 消息全文索引,sqlite使用FTS5,mysql使用FULLTEXT(ngram)
+	messageIndex interface {
+		// Index 写入索引
+		Index(id string, text string) error
+		// Supports 关键字是否可以使用索引检索,单字关键字需要回退到like
+		Supports(keyword string) bool
+		// Search 按相关度检索,返回结果包含score列
+		Search(q *MessageQuery, offset int, limit int) ([]searchHit, error)
+		// Unindexed 按id顺序读取after之后还没有索引的消息
+		Unindexed(after string, limit int) ([]message, error)
+	}
+
+	searchHit struct {
+		Row   message `gorm:"embedded"`
+		Score float64
+	}
+
+	sqliteMessageIndex struct {
+		db *gorm.DB
+	}
+
+	mysqlMessageIndex struct {
+		db *gorm.DB
+	}
+)
+
+func newMessageIndex(db *gorm.DB) (messageIndex, error) {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return &sqliteMessageIndex{db: db}, createSqliteIndex(db)
+	case "mysql":
+		err := db.Exec("CREATE TABLE IF NOT EXISTS message_fts (" +
+			"id varchar(50) NOT NULL PRIMARY KEY, " +
+			"body text, " +
+			"FULLTEXT KEY ft_message_body (body) WITH PARSER ngram" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4").Error
+		return &mysqlMessageIndex{db: db}, err
+	default:
+		return nil, fmt.Errorf("全文索引不支持数据库: %s", db.Dialector.Name())
+	}
+}
+
+// createSqliteIndex 消息表的rowid在VACUUM后可能变化,索引不能按rowid关联消息,
+// 另建 message_fts_doc 记录已索引的消息id及索引的rowid,按id关联时可以使用唯一索引
+func createSqliteIndex(db *gorm.DB) error {
+	upgrade := db.Migrator().HasTable("message_fts") && !db.Migrator().HasTable("message_fts_doc")
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS message_fts USING fts5(id UNINDEXED, body)").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("CREATE TABLE IF NOT EXISTS message_fts_doc (docid INTEGER PRIMARY KEY, id TEXT NOT NULL UNIQUE)").Error; err != nil {
+			return err
+		}
+		if !upgrade {
+			return nil
+		}
+		// 升级前的索引没有记录,按索引中的消息id补齐
+		return tx.Exec("INSERT OR IGNORE INTO message_fts_doc(docid, id) SELECT rowid, id FROM message_fts").Error
+	})
+}
+
+// messageText 从消息内容中提取需要索引的文本,文本消息为正文,文件消息为文件名
+func messageText(content string) string {
+	var part struct {
+		Content  string `json:"content"`
+		Filename string `json:"filename"`
+	}
+	if err := json.Unmarshal([]byte(content), &part); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(part.Content + " " + part.Filename)
+}
+
+// supportsKeyword 每个词至少两个字符才能命中二元组索引
+func supportsKeyword(keyword string) bool {
+	words := segment.Words(keyword)
+	if len(words) == 0 {
+		return false
+	}
+	for _, word := range words {
+		if len([]rune(word)) < 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Index 已经索引过的消息跳过,补建索引与保存消息同时写入时不会重复
+func (i *sqliteMessageIndex) Index(id string, text string) error {
+	return i.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("INSERT OR IGNORE INTO message_fts_doc(id) VALUES (?)", id)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Exec("INSERT INTO message_fts(rowid, id, body) SELECT docid, id, ? FROM message_fts_doc WHERE id = ?", segment.Tokenize(text), id).Error
+	})
+}
+
+func (i *sqliteMessageIndex) Unindexed(after string, limit int) ([]message, error) {
+	var rows []message
+	err := i.db.Table("message m").Select("m.id", "m.content").
+		Joins("LEFT JOIN message_fts_doc d ON d.id = m.id").
+		Where("d.id IS NULL AND m.id > ?", after).
+		Order("m.id").Limit(limit).Find(&rows).Error
+	return rows, err
+}
+
+func (i *sqliteMessageIndex) Supports(keyword string) bool {
+	return supportsKeyword(keyword)
+}
+
+// match 每个词作为一个短语,英文数字按前缀匹配,词之间为AND
+func (i *sqliteMessageIndex) match(keyword string) string {
+	phrases := make([]string, 0)
+	for _, word := range segment.Words(keyword) {
+		phrase := `"` + strings.Join(segment.Tokens(word), " ") + `"`
+		if []rune(word)[0] <= unicode.MaxASCII {
+			phrase += " *"
+		}
+		phrases = append(phrases, phrase)
+	}
+	return strings.Join(phrases, " AND ")
+}
+
+func (i *sqliteMessageIndex) Search(q *MessageQuery, offset int, limit int) ([]searchHit, error) {
+	db := i.db.Table("message_fts").
+		Select("m.*, -bm25(message_fts) AS score").
+		Joins("JOIN message m ON m.id = message_fts.id").
+		Where("message_fts MATCH ?", i.match(q.Keyword))
+	var hits []searchHit
+	err := q.filter(db, "m.").Order("score desc").Order("m.time desc").Offset(offset).Limit(limit).Scan(&hits).Error
+	return hits, err
+}
+
+func (i *mysqlMessageIndex) Index(id string, text string) error {
+	return i.db.Exec("INSERT INTO message_fts(id, body) VALUES (?, ?)", id, text).Error
+}
+
+func (i *mysqlMessageIndex) Unindexed(after string, limit int) ([]message, error) {
+	var rows []message
+	err := i.db.Table("message m").Select("m.id", "m.content").
+		Joins("LEFT JOIN message_fts f ON f.id = m.id").
+		Where("f.id IS NULL AND m.id > ?", after).
+		Order("m.id").Limit(limit).Find(&rows).Error
+	return rows, err
+}
+
+func (i *mysqlMessageIndex) Supports(keyword string) bool {
+	return supportsKeyword(keyword)
+}
+
+// against 布尔模式,每个词都必须出现
+func (i *mysqlMessageIndex) against(keyword string) string {
+	words := segment.Words(keyword)
+	for n, word := range words {
+		words[n] = `+"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+func (i *mysqlMessageIndex) Search(q *MessageQuery, offset int, limit int) ([]searchHit, error) {
+	against := i.against(q.Keyword)
+	db := i.db.Table("message_fts f").
+		Select("m.*, MATCH(f.body) AGAINST (? IN BOOLEAN MODE) AS score", against).
+		Joins("JOIN message m ON m.id = f.id").
+		Where("MATCH(f.body) AGAINST (? IN BOOLEAN MODE)", against)
+	var hits []searchHit
+	err := q.filter(db, "m.").Order("score desc").Order("m.time desc").Offset(offset).Limit(limit).Scan(&hits).Error
+	return hits, err
+}
+
+// BuildMessageIndex 创建全文索引并同步为已保存的消息补建索引,返回补建的条数,需要先创建消息表
+func BuildMessageIndex(db *gorm.DB) (int, error) {
+	index, err := newMessageIndex(db)
+	if err != nil {
+		return 0, err
+	}
+	return backfillIndex(index)
+}
+
+// backfillIndex 为开启索引前已保存的消息补建索引,按id游标分批读取,返回补建的条数
+func backfillIndex(index messageIndex) (int, error) {
+	total := 0
+	after := ""
+	for {
+		rows, err := index.Unindexed(after, backfillBatch)
+		if err != nil {
+			return total, err
+		}
+		for _, row := range rows {
+			if err = index.Index(row.ID, messageText(row.Content)); err != nil {
+				return total, fmt.Errorf("消息 %s: %w", row.ID, err)
+			}
+		}
+		total += len(rows)
+		if len(rows) < backfillBatch {
+			return total, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
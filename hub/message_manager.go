@@ -1,19 +1,77 @@
 package hub
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/eatmoreapple/openwechat"
 	"gorm.io/gorm"
+	"log/slog"
+	"strconv"
+	"strings"
 	"wechat-hub/pkg/lru"
+	"wechat-hub/pkg/segment"
+)
+
+const (
+	defaultQueryLimit = 20
+	maxQueryLimit     = 100
 )
 
 type (
 	MessageManager interface {
 		Exist(string) (bool, error)
 		Save(Message) error
+		Query(*MessageQuery) (*MessagePage, error)
+		// Each 按时间正序遍历符合条件的消息,fn返回错误时停止
+		Each(q *MessageQuery, fn func(StoredMessage) error) error
+	}
+
+	// MessageQuery 历史消息查询条件,为空的条件不参与查询
+	MessageQuery struct {
+		Chat    string   `json:"chatType"` // 会话类型 group:群聊,private:私聊
+		Gid     string   `json:"gid"`      // 群id
+		Uid     string   `json:"uid"`      // 用户id
+		Type    int      `json:"type"`     // 消息类型
+		Start   int64    `json:"start"`    // 开始时间(秒)
+		End     int64    `json:"end"`      // 结束时间(秒)
+		Keyword string   `json:"keyword"`  // 关键字
+		Cursor  string   `json:"cursor"`   // 分页游标,为上一页返回的next
+		Limit   int      `json:"limit"`    // 条数,默认20,最大100
+		Gids    []string `json:"-"`        // 限定的群范围,为nil时不限制,用于按授权过滤
+
+		cursorTime   int64
+		cursorID     string
+		cursorOffset int
+		cursorRanked bool // 游标是否为相关度排序的偏移量
+	}
+
+	// MessagePage 分页查询结果,next为空时表示没有更多数据
+	MessagePage struct {
+		Items []StoredMessage `json:"items"`
+		Next  string          `json:"next,omitempty"`
+	}
+
+	// StoredMessage 已保存的消息
+	StoredMessage struct {
+		ID        string          `json:"msgID"`
+		MsgType   int             `json:"msgType"`
+		Time      int64           `json:"time"`
+		ChatType  string          `json:"chatType,omitempty"`
+		GID       string          `json:"gid,omitempty"`
+		GroupName string          `json:"groupName,omitempty"`
+		UID       string          `json:"uid,omitempty"`
+		Nickname  string          `json:"username,omitempty"`
+		Content   json.RawMessage `json:"content"`
+		Score     float64         `json:"score,omitempty"`     // 相关度,仅全文检索时返回
+		Highlight string          `json:"highlight,omitempty"` // 高亮的文本,仅关键字查询时返回
 	}
+
 	message struct {
 		ID        string `gorm:"primaryKey;type:varchar(50)"`
 		MsgType   int    `gorm:"type:int(2)"`
 		Time      int64  `gorm:"type:int(20)"`
+		ChatType  string `gorm:"type:varchar(10)"`
 		GID       string `gorm:"column:gid;type:varchar(40)"`
 		GroupName string `gorm:"type:varchar(255)"`
 		UID       string `gorm:"column:uid;type:varchar(40)"`
@@ -24,17 +82,41 @@ type (
 	dbMessageManager struct {
 		db           *gorm.DB
 		existIdCache *lru.LRU[string, any]
+		index        messageIndex
 	}
+	MessageOption = func(*dbMessageManager)
 )
 
-func NewMessageManager(db *gorm.DB) MessageManager {
+// WithFullTextIndex 开启全文索引,sqlite使用FTS5,mysql使用ngram全文索引
+func WithFullTextIndex() MessageOption {
+	return func(d *dbMessageManager) {
+		index, err := newMessageIndex(d.db)
+		if err != nil {
+			panic(err)
+		}
+		d.index = index
+		go func() {
+			if count, err := backfillIndex(index); err != nil {
+				slog.Error("补建消息索引失败", "count", count, "err", err)
+			} else if count > 0 {
+				slog.Info("补建消息索引完成", "count", count)
+			}
+		}()
+	}
+}
+
+func NewMessageManager(db *gorm.DB, options ...MessageOption) MessageManager {
 	if err := db.AutoMigrate(message{}); err != nil {
 		panic(err)
 	}
-	return &dbMessageManager{
+	d := &dbMessageManager{
 		db:           db,
 		existIdCache: lru.New[string, any](1000),
 	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
 }
 
 func (d *dbMessageManager) Exist(id string) (bool, error) {
@@ -52,14 +134,241 @@ func (d *dbMessageManager) Exist(id string) (bool, error) {
 func (d *dbMessageManager) Save(msg Message) error {
 	groupId, groupName := msg.Group()
 	userId, nickname := msg.User()
-	return d.db.Create(&message{
+	content := msg.Message()
+	err := d.db.Create(&message{
 		ID:        msg.ID(),
 		MsgType:   msg.Type(),
 		Time:      msg.MsgTime(),
+		ChatType:  msg.Chat(),
 		GID:       groupId,
 		GroupName: groupName,
 		UID:       userId,
 		Nickname:  nickname,
-		Content:   msg.Message(),
+		Content:   content,
 	}).Error
+	if err == nil && d.index != nil {
+		if e := d.index.Index(msg.ID(), messageText(content)); e != nil {
+			slog.Error("写入消息索引失败", "msgId", msg.ID(), "err", e)
+		}
+	}
+	return err
+}
+
+func (q *MessageQuery) Validate() error {
+	if q.Start > 0 && q.End > 0 && q.Start > q.End {
+		return errors.New("开始时间不能大于结束时间")
+	}
+	if q.Cursor != "" {
+		var err error
+		if q.cursorTime, q.cursorID, q.cursorOffset, err = decodeCursor(q.Cursor); err != nil {
+			return err
+		}
+		q.cursorRanked = q.cursorID == ""
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultQueryLimit
+	} else if q.Limit > maxQueryLimit {
+		q.Limit = maxQueryLimit
+	}
+	return nil
+}
+
+// Query 按时间倒序分页查询历史消息,开启全文索引时关键字查询按相关度排序
+func (d *dbMessageManager) Query(q *MessageQuery) (*MessagePage, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	if q.Keyword != "" && d.index != nil && d.index.Supports(q.Keyword) {
+		return d.search(q)
+	}
+	if q.cursorRanked {
+		return nil, errors.New("分页游标错误")
+	}
+	db := q.filter(d.db.Model(&message{}), "")
+	if q.Keyword != "" {
+		db = db.Where("content like ? escape '!'", "%"+escapeLike(q.Keyword)+"%")
+	}
+	if q.Cursor != "" {
+		db = db.Where("time < ? or (time = ? and id < ?)", q.cursorTime, q.cursorTime, q.cursorID)
+	}
+	// 多查一条用于判断是否还有下一页
+	var rows []message
+	if err := db.Order("time desc").Order("id desc").Limit(q.Limit + 1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	page := &MessagePage{}
+	if len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+		last := rows[len(rows)-1]
+		page.Next = encodeCursor(last.Time, last.ID)
+	}
+	page.Items = make([]StoredMessage, 0, len(rows))
+	for _, row := range rows {
+		page.Items = append(page.Items, row.toStored(q.Keyword))
+	}
+	return page, nil
+}
+
+func (d *dbMessageManager) Each(q *MessageQuery, fn func(StoredMessage) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	var lastTime int64
+	var lastID string
+	for {
+		db := q.filter(d.db.Model(&message{}), "")
+		if lastID != "" {
+			db = db.Where("time > ? or (time = ? and id > ?)", lastTime, lastTime, lastID)
+		}
+		var rows []message
+		if err := db.Order("time").Order("id").Limit(maxQueryLimit).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := fn(row.toStored("")); err != nil {
+				return err
+			}
+		}
+		if len(rows) < maxQueryLimit {
+			return nil
+		}
+		last := rows[len(rows)-1]
+		lastTime, lastID = last.Time, last.ID
+	}
+}
+
+// search 使用全文索引按相关度查询,游标为偏移量
+func (d *dbMessageManager) search(q *MessageQuery) (*MessagePage, error) {
+	if q.Cursor != "" && !q.cursorRanked {
+		return nil, errors.New("分页游标错误")
+	}
+	hits, err := d.index.Search(q, q.cursorOffset, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &MessagePage{}
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+		page.Next = encodeOffsetCursor(q.cursorOffset + q.Limit)
+	}
+	page.Items = make([]StoredMessage, 0, len(hits))
+	for _, hit := range hits {
+		stored := hit.Row.toStored(q.Keyword)
+		stored.Score = hit.Score
+		page.Items = append(page.Items, stored)
+	}
+	return page, nil
+}
+
+// filter 拼接查询条件,prefix为字段的表前缀
+func (q *MessageQuery) filter(db *gorm.DB, prefix string) *gorm.DB {
+	if q.Chat != "" {
+		db = db.Where(prefix+"chat_type = ?", q.Chat)
+	}
+	if q.Gid != "" {
+		db = db.Where(prefix+"gid = ?", q.Gid)
+	}
+	if q.Gids != nil {
+		db = db.Where(prefix+"gid IN ?", q.Gids)
+	}
+	if q.Uid != "" {
+		db = db.Where(prefix+"uid = ?", q.Uid)
+	}
+	if q.Type != 0 {
+		db = db.Where(prefix+"msg_type = ?", q.Type)
+	}
+	if q.Start > 0 {
+		db = db.Where(prefix+"time >= ?", q.Start)
+	}
+	if q.End > 0 {
+		db = db.Where(prefix+"time <= ?", q.End)
+	}
+	return db
+}
+
+// escapeLike 转义like通配符,使用!作为转义符以兼容mysql及sqlite
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+func encodeCursor(time int64, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(time, 10) + ":" + id))
+}
+
+// encodeOffsetCursor 按相关度排序时使用偏移量作为游标
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("@" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (time int64, id string, offset int, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", 0, errors.New("分页游标错误")
+	}
+	if offsetStr, ok := strings.CutPrefix(string(raw), "@"); ok {
+		if offset, err = strconv.Atoi(offsetStr); err != nil || offset < 0 {
+			return 0, "", 0, errors.New("分页游标错误")
+		}
+		return 0, "", offset, nil
+	}
+	timeStr, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", 0, errors.New("分页游标错误")
+	}
+	if time, err = strconv.ParseInt(timeStr, 10, 64); err != nil {
+		return 0, "", 0, errors.New("分页游标错误")
+	}
+	return time, id, 0, nil
+}
+
+// toStored 转换为查询结果,keyword不为空时高亮文本中的关键字
+func (m message) toStored(keyword string) StoredMessage {
+	content := json.RawMessage(m.Content)
+	if !json.Valid(content) {
+		content, _ = json.Marshal(m.Content)
+	}
+	var highlight string
+	if keyword != "" {
+		highlight = segment.HighlightHTML(messageText(m.Content), segment.Words(keyword), highlightPre, highlightPost)
+	}
+	return StoredMessage{
+		ID:        m.ID,
+		MsgType:   m.MsgType,
+		Time:      m.Time,
+		ChatType:  m.ChatType,
+		GID:       m.GID,
+		GroupName: m.GroupName,
+		UID:       m.UID,
+		Nickname:  m.Nickname,
+		Content:   content,
+		Highlight: highlight,
+	}
+}
+
+// Restore 还原为原始格式的消息
+func (m StoredMessage) Restore() (Message, error) {
+	base := BaseMessage{
+		MsgType:   m.MsgType,
+		Time:      m.Time,
+		MsgID:     m.ID,
+		ChatType:  m.ChatType,
+		GID:       m.GID,
+		GroupName: m.GroupName,
+		UID:       m.UID,
+		Username:  m.Nickname,
+	}
+	switch openwechat.MessageType(m.MsgType) {
+	case openwechat.MsgTypeText:
+		msg := &TextMessage{BaseMessage: base}
+		return msg, json.Unmarshal(m.Content, msg)
+	case openwechat.MsgTypeSys:
+		msg := &SystemMessage{BaseMessage: base}
+		return msg, json.Unmarshal(m.Content, msg)
+	case openwechat.MsgTypeRecalled:
+		msg := &RevokedMessage{BaseMessage: base}
+		return msg, json.Unmarshal(m.Content, &msg.Revoke)
+	default:
+		msg := &MediaMessage{BaseMessage: base}
+		return msg, json.Unmarshal(m.Content, &msg.Media)
+	}
 }
@@ -0,0 +1,210 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxPending OutboxStatus = iota // 待投递
+	OutboxDone                        // 已确认
+	OutboxFailed                      // 超过重试次数
+
+	outboxErrorLength = 1000 // 截断后加省略号不超过字段长度
+)
+
+type (
+	OutboxStatus int8
+
+	OutboxManager interface {
+		// Add 记录一条待投递消息,target为转发器内的投递目标,lease时间内不会被重试任务捞取
+		Add(redirector string, target string, msgID string, payload []byte, lease time.Duration) (*Outbox, error)
+		// Ack 确认投递成功
+		Ack(id int64) error
+		// Fail 记录投递失败,超过最大重试次数后标记为失败
+		Fail(id int64, cause error) error
+		// Due 获取到期需要重试的消息,并顺延lease时间
+		Due(limit int, lease time.Duration) ([]Outbox, error)
+		// List 分页查询投递记录
+		List(redirector string, status OutboxStatus, offset, limit int) ([]Outbox, int64, error)
+		// Retry 将失败的消息重新加入投递队列
+		Retry(id int64) error
+		// Clean 清理doneBefore之前已确认的消息及failedBefore之前失败的消息
+		Clean(doneBefore, failedBefore time.Time) (int64, error)
+	}
+
+	Outbox struct {
+		ID         int64           `gorm:"primaryKey;autoIncrement" json:"id"`
+		Redirector string          `gorm:"type:varchar(100);index:idx_outbox_due,priority:2" json:"redirector"`
+		Target     string          `gorm:"type:varchar(500)" json:"target,omitempty"` // 有多个投递目标的转发器,每个目标单独记录
+		MsgID      string          `gorm:"column:msg_id;type:varchar(50)" json:"msgId"`
+		Payload    json.RawMessage `gorm:"" json:"payload"`
+		Status     OutboxStatus    `gorm:"index:idx_outbox_due,priority:1" json:"status"`
+		Attempts   int             `gorm:"" json:"attempts"`
+		NextTime   int64           `gorm:"index:idx_outbox_due,priority:3" json:"nextTime"` // 下次投递时间
+		LastError  string          `gorm:"type:varchar(1024)" json:"lastError,omitempty"`
+		CreateTime int64           `gorm:"autoCreateTime:milli" json:"createTime"`
+		UpdateTime int64           `gorm:"autoCreateTime:milli;autoUpdateTime:milli" json:"updateTime"`
+	}
+
+	dbOutboxManager struct {
+		db          *gorm.DB
+		maxAttempts int
+		backoff     time.Duration
+		maxBackoff  time.Duration
+	}
+	OutboxOption = func(*dbOutboxManager)
+)
+
+// OutboxMaxAttempts 最大重试次数
+func OutboxMaxAttempts(n int) OutboxOption {
+	return func(m *dbOutboxManager) {
+		if n > 0 {
+			m.maxAttempts = n
+		}
+	}
+}
+
+// OutboxBackoff 重试退避时间,每次失败翻倍直到max
+func OutboxBackoff(base, max time.Duration) OutboxOption {
+	return func(m *dbOutboxManager) {
+		if base > 0 {
+			m.backoff = base
+		}
+		if max >= m.backoff {
+			m.maxBackoff = max
+		}
+	}
+}
+
+func NewOutboxManager(db *gorm.DB, options ...OutboxOption) OutboxManager {
+	if err := db.AutoMigrate(Outbox{}); err != nil {
+		panic(err)
+	}
+	m := &dbOutboxManager{
+		db:          db,
+		maxAttempts: 20,
+		backoff:     5 * time.Second,
+		maxBackoff:  10 * time.Minute,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+func (m *dbOutboxManager) Add(redirector string, target string, msgID string, payload []byte, lease time.Duration) (*Outbox, error) {
+	entry := &Outbox{
+		Redirector: redirector,
+		Target:     target,
+		MsgID:      msgID,
+		Payload:    payload,
+		Status:     OutboxPending,
+		NextTime:   time.Now().Add(lease).UnixMilli(),
+	}
+	if err := m.db.Create(entry).Error; err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (m *dbOutboxManager) Ack(id int64) error {
+	return m.db.Model(&Outbox{}).Where("id = ?", id).Updates(map[string]any{
+		"status":     OutboxDone,
+		"last_error": "",
+	}).Error
+}
+
+func (m *dbOutboxManager) Fail(id int64, cause error) error {
+	var entry Outbox
+	if err := m.db.Where("id = ?", id).Take(&entry).Error; err != nil {
+		return err
+	}
+	entry.Attempts++
+	status := OutboxPending
+	if entry.Attempts >= m.maxAttempts {
+		status = OutboxFailed
+	}
+	lastError := ""
+	if cause != nil {
+		lastError = truncate(cause.Error(), outboxErrorLength)
+	}
+	return m.db.Model(&Outbox{}).Where("id = ?", id).Updates(map[string]any{
+		"status":     status,
+		"attempts":   entry.Attempts,
+		"next_time":  time.Now().Add(m.nextBackoff(entry.Attempts)).UnixMilli(),
+		"last_error": lastError,
+	}).Error
+}
+
+func (m *dbOutboxManager) nextBackoff(attempts int) time.Duration {
+	backoff := m.backoff
+	for i := 1; i < attempts && backoff < m.maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, m.maxBackoff)
+}
+
+func (m *dbOutboxManager) Due(limit int, lease time.Duration) ([]Outbox, error) {
+	now := time.Now()
+	var entries []Outbox
+	db := m.db.Where("status = ? and next_time <= ?", OutboxPending, now.UnixMilli()).
+		Order("id").Limit(limit).Find(&entries)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	due := entries[:0]
+	for _, entry := range entries {
+		// 乐观锁顺延,避免并发重复捞取
+		db = m.db.Model(&Outbox{}).
+			Where("id = ? and next_time = ?", entry.ID, entry.NextTime).
+			Update("next_time", now.Add(lease).UnixMilli())
+		if db.Error != nil {
+			return due, db.Error
+		}
+		if db.RowsAffected > 0 {
+			due = append(due, entry)
+		}
+	}
+	return due, nil
+}
+
+func (m *dbOutboxManager) List(redirector string, status OutboxStatus, offset, limit int) ([]Outbox, int64, error) {
+	db := m.db.Model(&Outbox{}).Where("status = ?", status)
+	if redirector != "" {
+		db = db.Where("redirector = ?", redirector)
+	}
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []Outbox
+	if err := db.Order("id").Offset(offset).Limit(limit).Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+func (m *dbOutboxManager) Retry(id int64) error {
+	db := m.db.Model(&Outbox{}).Where("id = ? and status = ?", id, OutboxFailed).Updates(map[string]any{
+		"status":    OutboxPending,
+		"attempts":  0,
+		"next_time": time.Now().UnixMilli(),
+	})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("消息不存在或未失败")
+	}
+	return nil
+}
+
+func (m *dbOutboxManager) Clean(doneBefore, failedBefore time.Time) (int64, error) {
+	db := m.db.Where("(status = ? and update_time < ?) or (status = ? and update_time < ?)",
+		OutboxDone, doneBefore.UnixMilli(), OutboxFailed, failedBefore.UnixMilli()).Delete(&Outbox{})
+	return db.RowsAffected, db.Error
+}
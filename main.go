@@ -9,56 +9,51 @@ import (
 	"os"
 	"os/signal"
 	"path"
-	"strconv"
 	"time"
 	"wechat-hub/auth"
+	"wechat-hub/config"
 	"wechat-hub/hub"
+	"wechat-hub/pkg/redact"
 	"wechat-hub/storage"
 
 	"github.com/eatmoreapple/openwechat"
 	"github.com/glebarez/sqlite"
+	"golang.org/x/time/rate"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
 
-var (
-	dataDir    string
-	httpPort   int
-	wsPort     int
-	mqttPort   int
-	mqttWSPort int
-)
+var cfg *config.Config
 
 func init() {
-	// 创建缓存目录
-	dataDir = os.Getenv("DATA")
-	if dataDir == "" {
-		dataDir = "./data"
-	}
-	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
-		panic(err)
-	}
-
-	// http端口
-	httpPort, _ = strconv.Atoi(os.Getenv("APP_PORT"))
-	if httpPort == 0 {
-		httpPort = 8080
-	}
+	// 日志中的凭据字段统一脱敏
+	slog.SetDefault(slog.New(redact.NewTextHandler(os.Stdout, slog.LevelInfo)))
+}
 
-	wsPort, _ = strconv.Atoi(os.Getenv("WS_PORT"))
-	if wsPort == 0 {
-		wsPort = 18080
+// loadConfig 读取配置并创建数据目录
+func loadConfig() error {
+	c, err := config.Load("")
+	if err != nil {
+		return err
 	}
-	mqttPort, _ = strconv.Atoi(os.Getenv("MQTT_PORT"))
-	if mqttPort == 0 {
-		mqttPort = 1883
+	if err = os.MkdirAll(c.Data, os.ModePerm); err != nil {
+		return fmt.Errorf("创建数据目录失败: %w", err)
 	}
-	mqttWSPort, _ = strconv.Atoi(os.Getenv("MQTT_WS_PORT"))
+	cfg = c
+	return nil
 }
 
 func main() {
+	// 带参数时执行管理命令,不启动服务
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
@@ -72,15 +67,43 @@ func main() {
 
 	// 资源管理器
 	memberManager := hub.NewMemberManger(bot, db)
-	messageManager := hub.NewMessageManager(db)
-	store := storage.NewLocalStorage(path.Join(dataDir, "files"))
+	var messageOptions []hub.MessageOption
+	if cfg.Message.FTS {
+		messageOptions = append(messageOptions, hub.WithFullTextIndex())
+	}
+	messageManager := hub.NewMessageManager(db, messageOptions...)
+	outbox := hub.NewOutboxManager(db,
+		hub.OutboxMaxAttempts(cfg.Outbox.MaxAttempts),
+		hub.OutboxBackoff(cfg.Outbox.Backoff, cfg.Outbox.MaxBackoff),
+	)
+	audit := hub.NewAuditManager(db)
+	store := storage.NewLocalStorage(cfg.Storage.Path)
 
 	// 消息发送
-	sender := NewMsgSender(bot, memberManager, store)
+	sender := NewMsgSender(bot, memberManager, store,
+		WithLimit(rate.Every(cfg.Sender.Interval), cfg.Sender.Burst),
+		WithAudit(audit),
+	)
 	// 消息转发器
 	h := NewHub(ctx, memberManager, messageManager, store, authManager)
-	h.UseWebsocketServerRedirect(wsPort, time.Second*10)
-	h.UseMQTTServerRedirect(path.Join(dataDir, "mqtt"), mqttPort, mqttWSPort)
+	h.SetOutbox(outbox)
+	httpOptions := []HttpHandlerOption{
+		WithMaxUploadSize(int(cfg.HTTP.MaxUploadSize)),
+		WithBaseAuth(authManager),
+		WithMessage(messageManager),
+		WithOutbox(outbox),
+		WithAuditLog(audit),
+		WithReplayer(h),
+	}
+	for _, r := range cfg.Redirects {
+		if err := h.UseRedirect(r.Name, r.Spec, r.Filter, cfg.Data, db); err != nil {
+			panic(err)
+		}
+		// 事件流由HTTP服务提供
+		if stream, ok := h.redirects[r.Name].(EventStream); ok {
+			httpOptions = append(httpOptions, WithEventStream(stream))
+		}
+	}
 	// 消息处理器
 	dispatcher := openwechat.NewMessageMatchDispatcher()
 	dispatcher.SetAsync(true)
@@ -94,7 +117,7 @@ func main() {
 	bot.MessageHandler = dispatcher.AsMessageHandler()
 	// 桌面模式
 	openwechat.Desktop.Prepare(bot)
-	if err := bot.HotLogin(openwechat.NewFileHotReloadStorage(path.Join(dataDir, "login.json")), &openwechat.RetryLoginOption{MaxRetryCount: 3}); err != nil {
+	if err := bot.HotLogin(openwechat.NewFileHotReloadStorage(path.Join(cfg.Data, "login.json")), &openwechat.RetryLoginOption{MaxRetryCount: 3}); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Warn("Login canceled")
 			os.Exit(0)
@@ -115,49 +138,24 @@ func main() {
 		}
 		slog.Info("Login", "code", code, "msg", code.String())
 	}
+	bot.LogoutCallBack = func(_ *openwechat.Bot) {
+		h.PublishState()
+	}
 	memberManager.RefreshGroupMember()
-	h.StartWatchMembers()
-	go NewHttpHandler(store, memberManager, sender, WithBaseAuth(authManager)).ListenAndServe(httpPort)
+	h.PublishState()
+	h.StartWatchMembers(cfg.Members.Schedules)
+	h.StartOutbox()
+	go NewHttpHandler(store, memberManager, sender, httpOptions...).ListenAndServe(cfg.HTTP.Port)
 	<-ctx.Done()
 }
 
 func connectDB() *gorm.DB {
 	var dialector gorm.Dialector
-	dbType := os.Getenv("DB")
-	switch dbType {
+	switch cfg.DB.Type {
 	case "mysql":
-		username := os.Getenv("MYSQL_USERNAME")
-		if username == "" {
-			username = "root"
-		}
-		password := os.Getenv("MYSQL_PASSWORD")
-		if password == "" {
-			password = "root"
-		}
-		host := os.Getenv("MYSQL_HOST")
-		if host == "" {
-			host = "127.0.0.1"
-		}
-		port := os.Getenv("MYSQL_PORT")
-		if port == "" {
-			port = "3306"
-		}
-		database := os.Getenv("MYSQL_DATABASE")
-		parameters := os.Getenv("MYSQL_PARAMETERS")
-		dialector = mysql.Open(fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s", []interface{}{
-			username,
-			password,
-			host,
-			port,
-			database,
-			parameters,
-		}...))
-	case "sqlite":
-	case "":
-		dialector = sqlite.Open(path.Join(dataDir, "database.sqlite"))
-		break
+		dialector = mysql.Open(cfg.DB.MySQL.DSN())
 	default:
-		panic("unknown db type")
+		dialector = sqlite.Open(cfg.DB.Path)
 	}
 
 	db, err := gorm.Open(dialector, &gorm.Config{
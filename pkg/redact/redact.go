@@ -0,0 +1,40 @@
+package redact
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Mask 脱敏后的替换值
+const Mask = "******"
+
+// sensitiveKeys 日志字段名包含这些词时视为凭据
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "authorization", "cookie"}
+
+// IsSensitive 字段名是否为凭据
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceAttr 用于 slog.HandlerOptions,凭据字段输出前替换为掩码
+func ReplaceAttr(_ []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() != slog.KindGroup && IsSensitive(attr.Key) {
+		return slog.String(attr.Key, Mask)
+	}
+	return attr
+}
+
+// NewTextHandler 创建带凭据脱敏的文本日志处理器
+func NewTextHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: ReplaceAttr,
+	})
+}
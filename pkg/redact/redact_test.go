@@ -0,0 +1,23 @@
+package redact
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewTextHandler(&buf, slog.LevelInfo))
+	logger.With("token", "abc").Info("login", "username", "admin", "password", "123456", slog.Group("req", "Authorization", "Basic xyz"))
+	out := buf.String()
+	for _, leaked := range []string{"abc", "123456", "xyz"} {
+		if strings.Contains(out, leaked) {
+			t.Fatalf("credential %q leaked: %s", leaked, out)
+		}
+	}
+	if !strings.Contains(out, "username=admin") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
@@ -0,0 +1,124 @@
+package segment
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// isCJK 是否为中日韩文字,此类文字没有空格分词,按二元组切分
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Words 将文本切分为词,非中日韩文字按空白及标点切分并转为小写,中日韩文字连续的部分作为一个词
+func Words(text string) []string {
+	var words []string
+	var word []rune
+	cjk := false
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if !cjk {
+				flush()
+			}
+			cjk = true
+			word = append(word, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if cjk {
+				flush()
+			}
+			cjk = false
+			word = append(word, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// Tokens 将词切分为索引词元,中日韩文字切分为重叠的二元组,单字保留原样
+func Tokens(word string) []string {
+	runes := []rune(word)
+	if len(runes) < 2 || !isCJK(runes[0]) {
+		return []string{word}
+	}
+	tokens := make([]string, 0, len(runes)-1)
+	for i := 0; i < len(runes)-1; i++ {
+		tokens = append(tokens, string(runes[i:i+2]))
+	}
+	return tokens
+}
+
+// Tokenize 将文本切分为以空格分隔的词元,用于写入全文索引
+func Tokenize(text string) string {
+	var tokens []string
+	for _, word := range Words(text) {
+		tokens = append(tokens, Tokens(word)...)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// Highlight 使用pre/post标记文本中出现的关键字,忽略大小写
+func Highlight(text string, words []string, pre, post string) string {
+	return highlight(text, words, pre, post, false)
+}
+
+// HighlightHTML 与 Highlight 相同,但先转义文本中的html字符,标记可以直接作为html显示
+func HighlightHTML(text string, words []string, pre, post string) string {
+	return highlight(text, words, pre, post, true)
+}
+
+func highlight(text string, words []string, pre, post string, escape bool) string {
+	if text == "" {
+		return text
+	}
+	if len(words) == 0 {
+		if escape {
+			return html.EscapeString(text)
+		}
+		return text
+	}
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// 大小写转换改变了长度时不做忽略大小写处理
+		lower = runes
+	}
+	marked := make([]bool, len(runes))
+	for _, word := range words {
+		w := []rune(strings.ToLower(word))
+		if len(w) == 0 {
+			continue
+		}
+		for i := 0; i+len(w) <= len(lower); i++ {
+			if string(lower[i:i+len(w)]) == string(w) {
+				for j := i; j < i+len(w); j++ {
+					marked[j] = true
+				}
+			}
+		}
+	}
+	var b strings.Builder
+	for i, r := range runes {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(pre)
+		}
+		if escape {
+			b.WriteString(html.EscapeString(string(r)))
+		} else {
+			b.WriteRune(r)
+		}
+		if marked[i] && (i == len(runes)-1 || !marked[i+1]) {
+			b.WriteString(post)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package segment
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	words := Words("Hello,世界你好 go1.22版本")
+	expect := []string{"hello", "世界你好", "go1", "22", "版本"}
+	if !reflect.DeepEqual(words, expect) {
+		t.Fatalf("Words() = %v, want %v", words, expect)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	if tokens := Tokenize("你好世界 Go"); tokens != "你好 好世 世界 go" {
+		t.Fatalf("Tokenize() = %q", tokens)
+	}
+	if tokens := Tokenize("好"); tokens != "好" {
+		t.Fatalf("Tokenize() = %q", tokens)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	text := Highlight("Hello 世界, hello", []string{"hello", "世界"}, "<em>", "</em>")
+	if text != "<em>Hello</em> <em>世界</em>, <em>hello</em>" {
+		t.Fatalf("Highlight() = %q", text)
+	}
+}
+
+func TestHighlightHTML(t *testing.T) {
+	cases := []struct {
+		text   string
+		words  []string
+		expect string
+	}{
+		{"<b>hello</b>", []string{"hello"}, "&lt;b&gt;<em>hello</em>&lt;/b&gt;"},
+		{"a<em>b", []string{"em"}, "a&lt;<em>em</em>&gt;b"},
+		{"\"x\" & y", nil, "&#34;x&#34; &amp; y"},
+		{"", []string{"x"}, ""},
+	}
+	for _, c := range cases {
+		if text := HighlightHTML(c.text, c.words, "<em>", "</em>"); text != c.expect {
+			t.Errorf("HighlightHTML(%q) = %q, want %q", c.text, text, c.expect)
+		}
+	}
+}
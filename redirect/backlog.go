@@ -0,0 +1,286 @@
+package redirect
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wechat-hub/hub"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	backlogBatch    = 100              // 每次补发读取的条数
+	backlogInterval = 10 * time.Minute // 清理过期积压的间隔
+)
+
+type (
+	// backlog 为消息编号并持久化,断线重连的客户端从确认的序号之后补发
+	// db为空时只编号不持久化,重启后序号从1开始
+	backlog struct {
+		db        *gorm.DB
+		stream    string // 转发器名称,每个转发器独立编号
+		retention time.Duration
+		mu        sync.Mutex   // 保证编号与写入顺序一致
+		seq       atomic.Int64 // 最后写入的序号,写入积压后才更新,读取时不需要等待写入
+	}
+
+	wsBacklog struct {
+		Stream  string `gorm:"primaryKey;size:100"`
+		Seq     int64  `gorm:"primaryKey;autoIncrement:false"`
+		Payload []byte `gorm:"not null"`
+		Time    int64  `gorm:"index"`
+	}
+
+	// wsCursor 客户端最后确认的序号
+	wsCursor struct {
+		Stream     string `gorm:"primaryKey;size:100"`
+		Client     string `gorm:"primaryKey;size:200"`
+		Seq        int64  `gorm:"not null"`
+		UpdateTime int64  `gorm:"autoCreateTime:milli;autoUpdateTime:milli"`
+	}
+
+	// frame 已编号的消息
+	frame struct {
+		seq     int64
+		payload []byte
+	}
+
+	// position 连接的发送位置,补发积压消息期间暂停实时消息
+	position struct {
+		mu       sync.Mutex
+		resuming bool
+		lastSeq  int64 // 已发送的最大序号
+		acked    atomic.Int64
+	}
+)
+
+func (wsBacklog) TableName() string {
+	return "ws_backlog"
+}
+
+func (wsCursor) TableName() string {
+	return "ws_cursor"
+}
+
+// Migrate 创建或升级转发器使用的数据表
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(wsBacklog{}, wsCursor{}, mqttStore{})
+}
+
+// newBacklog 创建积压队列,retention为0时不持久化
+func newBacklog(ctx context.Context, db *gorm.DB, stream string, retention time.Duration) (*backlog, error) {
+	b := &backlog{stream: stream, retention: retention}
+	if db == nil || retention <= 0 {
+		return b, nil
+	}
+	if err := db.AutoMigrate(wsBacklog{}, wsCursor{}); err != nil {
+		return nil, err
+	}
+	// 从已保存的最大序号继续编号,清理时总会保留最后一条
+	var last *int64
+	if err := db.Model(&wsBacklog{}).Where("stream = ?", stream).Select("max(seq)").Scan(&last).Error; err != nil {
+		return nil, err
+	}
+	if last != nil {
+		b.seq.Store(*last)
+	}
+	b.db = db
+	go b.clean(ctx)
+	return b, nil
+}
+
+// persistent 是否持久化,不持久化时无法补发
+func (b *backlog) persistent() bool {
+	return b.db != nil
+}
+
+// append 为消息编号并保存,返回带序号的消息
+func (b *backlog) append(payload []byte) (frame, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f := frame{seq: b.seq.Load() + 1}
+	f.payload = stamp(f.seq, payload)
+	if b.db != nil {
+		if err := b.db.Create(&wsBacklog{Stream: b.stream, Seq: f.seq, Payload: f.payload, Time: time.Now().UnixMilli()}).Error; err != nil {
+			return frame{}, err
+		}
+	}
+	b.seq.Store(f.seq)
+	return f, nil
+}
+
+// last 最后一条消息的序号
+func (b *backlog) last() int64 {
+	return b.seq.Load()
+}
+
+// clamp 补发的起始序号不能超过最新序号,否则之后的实时消息会被当作已发送跳过
+func (b *backlog) clamp(seq int64) int64 {
+	return max(0, min(seq, b.last()))
+}
+
+// oldest 保留的最早消息的序号,没有消息时为0
+func (b *backlog) oldest() (int64, error) {
+	if b.db == nil {
+		return 0, nil
+	}
+	var first *int64
+	if err := b.db.Model(&wsBacklog{}).Where("stream = ?", b.stream).Select("min(seq)").Scan(&first).Error; err != nil {
+		return 0, err
+	}
+	if first == nil {
+		return 0, nil
+	}
+	return *first, nil
+}
+
+// resumeResult 校正补发的起始序号,并检查之后的消息是否已被清理
+func (b *backlog) resumeResult(seq int64) (hub.ResumeResult, error) {
+	result := hub.ResumeResult{From: b.clamp(seq)}
+	oldest, err := b.oldest()
+	if err != nil {
+		return result, err
+	}
+	if oldest > result.From+1 {
+		result.Gap, result.Oldest = true, oldest
+	}
+	return result, nil
+}
+
+// after 读取序号之后的消息
+func (b *backlog) after(seq int64, limit int) ([]frame, error) {
+	if b.db == nil {
+		return nil, nil
+	}
+	var rows []wsBacklog
+	if err := b.db.Where("stream = ? AND seq > ?", b.stream, seq).Order("seq").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	frames := make([]frame, len(rows))
+	for i, row := range rows {
+		frames[i] = frame{seq: row.Seq, payload: row.Payload}
+	}
+	return frames, nil
+}
+
+// acked 客户端最后确认的序号,没有确认过时返回false
+func (b *backlog) acked(client string) (int64, bool, error) {
+	if b.db == nil {
+		return 0, false, nil
+	}
+	var cursor wsCursor
+	err := b.db.Where("stream = ? AND client = ?", b.stream, client).Limit(1).Find(&cursor).Error
+	return cursor.Seq, cursor.Client != "", err
+}
+
+// ack 记录客户端确认的序号
+func (b *backlog) ack(client string, seq int64) error {
+	if b.db == nil {
+		return nil
+	}
+	return b.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "stream"}, {Name: "client"}},
+		DoUpdates: clause.AssignmentColumns([]string{"seq", "update_time"}),
+	}).Create(&wsCursor{Stream: b.stream, Client: client, Seq: seq}).Error
+}
+
+// clean 定期清理超过保留时间的消息
+func (b *backlog) clean(ctx context.Context) {
+	ticker := time.NewTicker(backlogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			seq := b.seq.Load()
+			result := b.db.Where("stream = ? AND time < ? AND seq < ?", b.stream, now.Add(-b.retention).UnixMilli(), seq).Delete(&wsBacklog{})
+			if result.Error != nil {
+				slog.Error("清理积压消息失败", "stream", b.stream, "err", result.Error)
+			} else if result.RowsAffected > 0 {
+				slog.Info("清理积压消息", "stream", b.stream, "count", result.RowsAffected)
+			}
+		}
+	}
+}
+
+// deliver 发送实时消息,补发期间及已发送过的消息跳过
+func (p *position) deliver(f frame, send func(f frame) error) error {
+	p.mu.Lock()
+	if p.resuming || f.seq <= p.lastSeq {
+		p.mu.Unlock()
+		return nil
+	}
+	p.lastSeq = f.seq
+	p.mu.Unlock()
+	return send(f)
+}
+
+// start 开始补发,补发期间实时消息暂停
+func (p *position) start() {
+	p.mu.Lock()
+	p.resuming = true
+	p.mu.Unlock()
+}
+
+// resume 从seq之后补发积压消息,补发完成后恢复实时消息,调用前需要先调用start
+func (p *position) resume(b *backlog, seq int64, send func(f frame) error) error {
+	seq = b.clamp(seq)
+	defer func() {
+		p.mu.Lock()
+		p.resuming = false
+		p.mu.Unlock()
+	}()
+	for {
+		// 查询前记录最新序号,该序号之前的消息都已写入积压
+		head := b.last()
+		frames, err := b.after(seq, backlogBatch)
+		if err != nil {
+			return err
+		}
+		if len(frames) == 0 {
+			// 查询期间没有新消息时恢复实时消息,之后写入的消息在加锁后才能投递,由实时消息发送;
+			// 持有锁时只比较内存中的序号,不查询数据库,避免阻塞同一转发器的其他连接
+			p.mu.Lock()
+			done := b.last() == head
+			if done {
+				// 补发开始前可能已经发送过更新的实时消息
+				p.lastSeq = max(p.lastSeq, seq)
+				p.resuming = false
+			}
+			p.mu.Unlock()
+			if done {
+				return nil
+			}
+			continue
+		}
+		for _, f := range frames {
+			if err = send(f); err != nil {
+				return err
+			}
+			seq = f.seq
+		}
+	}
+}
+
+// stamp 在消息json对象中加入序号字段 seq
+func stamp(seq int64, payload []byte) []byte {
+	body := bytes.TrimSpace(payload)
+	if len(body) < 2 || body[0] != '{' {
+		return payload
+	}
+	body = bytes.TrimSpace(body[1:])
+	stamped := make([]byte, 0, len(body)+24)
+	stamped = append(stamped, `{"seq":`...)
+	stamped = strconv.AppendInt(stamped, seq, 10)
+	if body[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, body...)
+}
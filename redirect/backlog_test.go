@@ -0,0 +1,125 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func testBacklog(t *testing.T, stream string) *backlog {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/backlog.db"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	b, err := newBacklog(ctx, db, stream, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestStamp(t *testing.T) {
+	cases := []struct {
+		payload string
+		expect  string
+	}{
+		{`{"a":1}`, `{"seq":7,"a":1}`},
+		{`{}`, `{"seq":7}`},
+		{` { "a":1 } `, `{"seq":7,"a":1 }`},
+		{"{\n}", `{"seq":7}`},
+		{`[1]`, `[1]`},
+		{`"x"`, `"x"`},
+		{`{`, `{`},
+		{``, ``},
+	}
+	for _, c := range cases {
+		if stamped := string(stamp(7, []byte(c.payload))); stamped != c.expect {
+			t.Errorf("stamp(%q) = %q, want %q", c.payload, stamped, c.expect)
+		}
+	}
+}
+
+// TestPositionResume 补发期间写入的消息由补发或实时消息发送,每条只发送一次且按顺序
+func TestPositionResume(t *testing.T) {
+	cases := []struct {
+		name     string
+		existing int   // 补发前积压的消息数
+		from     int64 // 补发的起始序号
+		during   int   // 补发期间写入并实时发送的消息数
+		after    int   // 补发完成后写入的消息数
+		expect   []int64
+	}{
+		{"empty", 0, 0, 0, 2, []int64{1, 2}},
+		{"all", 3, 0, 0, 1, []int64{1, 2, 3, 4}},
+		{"acked", 3, 2, 0, 1, []int64{3, 4}},
+		{"up to date", 3, 3, 0, 2, []int64{4, 5}},
+		{"ahead of head", 3, 100, 0, 1, []int64{4}},
+		{"negative", 2, -5, 0, 0, []int64{1, 2}},
+		{"live during resume", 3, 1, 2, 1, []int64{2, 3, 4, 5, 6}},
+		{"more than a batch", backlogBatch + 5, backlogBatch, 3, 1, seqs(backlogBatch+1, backlogBatch+9)},
+	}
+	for _, c := range cases {
+		b := testBacklog(t, c.name)
+		var live []frame
+		appendFrames := func(n int) {
+			for i := 0; i < n; i++ {
+				f, err := b.append([]byte(fmt.Sprintf(`{"n":%d}`, i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				live = append(live, f)
+			}
+		}
+		appendFrames(c.existing)
+		var sent []int64
+		send := func(f frame) error {
+			sent = append(sent, f.seq)
+			return nil
+		}
+		var p position
+		p.start()
+		injected := false
+		err := p.resume(b, c.from, func(f frame) error {
+			if !injected {
+				// 补发期间新写入的消息,实时发送会被跳过,由补发继续发送
+				injected = true
+				start := len(live)
+				appendFrames(c.during)
+				for _, f := range live[start:] {
+					if err := p.deliver(f, send); err != nil {
+						return err
+					}
+				}
+			}
+			return send(f)
+		})
+		if err != nil {
+			t.Fatalf("%s: resume() = %v", c.name, err)
+		}
+		appendFrames(c.after)
+		// 实时消息晚于补发到达时也不能重复发送
+		for _, f := range live {
+			if err := p.deliver(f, send); err != nil {
+				t.Fatalf("%s: deliver() = %v", c.name, err)
+			}
+		}
+		if !reflect.DeepEqual(sent, c.expect) {
+			t.Errorf("%s: sent %v, want %v", c.name, sent, c.expect)
+		}
+	}
+}
+
+func seqs(from, to int64) []int64 {
+	var s []int64
+	for seq := from; seq <= to; seq++ {
+		s = append(s, seq)
+	}
+	return s
+}
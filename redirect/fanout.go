@@ -0,0 +1,235 @@
+package redirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"wechat-hub/auth"
+)
+
+var ErrNoClient = errors.New("没有在线的客户端")
+
+type (
+	// fanout 为消息编号后放入各连接的发送队列,慢连接不影响其他连接,断线重连的连接从积压中补发
+	// websocket服务端、事件流及gRPC订阅内嵌使用
+	fanout struct {
+		ctx       context.Context
+		backlog   *backlog
+		sendMu    sync.Mutex // 保证编号顺序与入队顺序一致
+		clientsMu sync.RWMutex
+		clients   map[*streamClient]struct{}
+	}
+
+	// streamClient 接收分发消息的连接
+	streamClient struct {
+		info          *ClientInfo
+		identity      *auth.Identity
+		subscriptions subscriptions
+		queue         *clientQueue
+		position      position
+		encode        func(seq int64, payload []byte) []byte // 消息在连接上的格式,为空时原样发送
+		disconnect    func(err error)                        // 入队失败时断开连接,在关闭队列之前调用
+	}
+
+	// ackGroup 多个连接都写入后回调一次,任一连接失败时回调第一个错误
+	ackGroup struct {
+		mu      sync.Mutex
+		pending int
+		err     error
+		ack     func(err error)
+	}
+)
+
+func newFanout(ctx context.Context) fanout {
+	return fanout{
+		ctx:     ctx,
+		backlog: &backlog{},
+		clients: make(map[*streamClient]struct{}),
+	}
+}
+
+func (f *fanout) add(c *streamClient) {
+	f.clientsMu.Lock()
+	f.clients[c] = struct{}{}
+	f.clientsMu.Unlock()
+}
+
+// remove 移除连接并关闭发送队列
+func (f *fanout) remove(c *streamClient) {
+	f.clientsMu.Lock()
+	delete(f.clients, c)
+	f.clientsMu.Unlock()
+	c.queue.close()
+}
+
+func (f *fanout) online() int {
+	f.clientsMu.RLock()
+	defer f.clientsMu.RUnlock()
+	return len(f.clients)
+}
+
+// SendMessage 编号后放入接收该消息的连接的队列
+func (f *fanout) SendMessage(bytes []byte) error {
+	return f.SendMessageAck(bytes, nil)
+}
+
+// SendMessageAck 保存积压时写入积压后确认,断线的连接可以补发;
+// 不保存积压时接收该消息的连接都写入后确认,任一连接未写入时确认失败
+func (f *fanout) SendMessageAck(bytes []byte, ack func(err error)) error {
+	// 没有连接且不保存积压时返回错误,由投递箱稍后重试
+	if f.online() == 0 && !f.backlog.persistent() {
+		return ErrNoClient
+	}
+	f.sendMu.Lock()
+	defer f.sendMu.Unlock()
+	fr, err := f.backlog.append(bytes)
+	if err != nil {
+		return err
+	}
+	if ack != nil && f.backlog.persistent() {
+		ack(nil)
+		ack = nil
+	}
+	group := newAckGroup(ack)
+	defer group.done(nil)
+	header := parseHeader(fr.payload)
+	f.clientsMu.RLock()
+	defer f.clientsMu.RUnlock()
+	for c := range f.clients {
+		if !c.accept(header) {
+			continue
+		}
+		err := c.position.deliver(fr, func(fr frame) error {
+			return c.sendAck(fr, group.add())
+		})
+		if err != nil && !errors.Is(err, errQueueClosed) {
+			slog.Error("发送消息失败,断开连接", "client", c.info.ID, "user", c.info.User, "err", err)
+		}
+	}
+	return nil
+}
+
+// resume 从seq之后补发积压消息,调用前需要先调用 position.start
+func (f *fanout) resume(c *streamClient, seq int64) {
+	err := c.position.resume(f.backlog, seq, func(fr frame) error {
+		if !c.accept(parseHeader(fr.payload)) {
+			return nil
+		}
+		// 等待队列有空位,补发不触发溢出处理
+		if !c.queue.wait(f.ctx) {
+			return errQueueClosed
+		}
+		return c.send(fr)
+	})
+	if err != nil && !errors.Is(err, errQueueClosed) {
+		slog.Error("补发积压消息失败", "client", c.info.ID, "user", c.info.User, "err", err)
+	}
+}
+
+// Clients 当前的连接
+func (f *fanout) Clients() []ClientInfo {
+	f.clientsMu.RLock()
+	defer f.clientsMu.RUnlock()
+	clients := make([]ClientInfo, 0, len(f.clients))
+	for c := range f.clients {
+		info := *c.info
+		info.Subscriptions = c.subscriptions.list()
+		stats := c.queue.stats()
+		info.Queue = &stats
+		info.Acked = c.position.acked.Load()
+		clients = append(clients, info)
+	}
+	return clients
+}
+
+// SendTo 发送消息到指定连接,不分配序号
+func (f *fanout) SendTo(id string, bytes []byte) error {
+	f.clientsMu.RLock()
+	var target *streamClient
+	for c := range f.clients {
+		if c.info.ID == id {
+			target = c
+			break
+		}
+	}
+	f.clientsMu.RUnlock()
+	if target == nil {
+		return fmt.Errorf("客户端 %s 不在线", id)
+	}
+	return target.send(frame{payload: bytes})
+}
+
+// accept 没有接收消息权限的连接只能发送命令,受群限制的连接只接收授权群的消息
+func (c *streamClient) accept(header messageHeader) bool {
+	return c.identity.Allow(auth.ScopeReadMessages) && c.identity.AllowGroup(header.GID) && c.subscriptions.match(header)
+}
+
+func (c *streamClient) send(f frame) error {
+	return c.sendAck(f, nil)
+}
+
+// sendAck 放入发送队列,写入连接后回调done
+func (c *streamClient) sendAck(f frame, done func(err error)) error {
+	payload := f.payload
+	if c.encode != nil {
+		payload = c.encode(f.seq, f.payload)
+	}
+	return c.pushAck(payload, done)
+}
+
+func (c *streamClient) push(payload []byte) error {
+	return c.pushAck(payload, nil)
+}
+
+// pushAck 放入发送队列,入队失败时断开连接并回调done
+func (c *streamClient) pushAck(payload []byte, done func(err error)) error {
+	if err := c.queue.push(payload, done); err != nil {
+		if done != nil {
+			done(err)
+		}
+		// 先通知断开原因再关闭队列,等待队列的一方可以看到原因
+		if c.disconnect != nil && !errors.Is(err, errQueueClosed) {
+			c.disconnect(err)
+		}
+		c.queue.close()
+		return err
+	}
+	return nil
+}
+
+// newAckGroup ack为空时返回nil,不需要跟踪写入结果
+func newAckGroup(ack func(err error)) *ackGroup {
+	if ack == nil {
+		return nil
+	}
+	return &ackGroup{pending: 1, ack: ack}
+}
+
+// add 增加一个等待写入的连接,返回该连接写入后的回调
+func (g *ackGroup) add() func(err error) {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	g.pending++
+	g.mu.Unlock()
+	return g.done
+}
+
+func (g *ackGroup) done(err error) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	if err != nil && g.err == nil {
+		g.err = err
+	}
+	g.pending--
+	finished := g.pending == 0
+	g.mu.Unlock()
+	if finished {
+		g.ack(g.err)
+	}
+}
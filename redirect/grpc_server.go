@@ -0,0 +1,431 @@
+package redirect
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"wechat-hub/auth"
+	"wechat-hub/grpcapi"
+	"wechat-hub/hub"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+type (
+	// GRPCRedirector gRPC服务,一元接口转换为命令交给hub处理,Subscribe推送实时消息
+	// 队列满时结束该流,客户端携带最后收到的seq重新订阅后从积压中补发
+	GRPCRedirector struct {
+		grpcapi.UnimplementedHubServer
+		fanout
+		server    *grpc.Server
+		auth      *auth.Manager
+		onMessage OnMessage
+		queueSize int
+	}
+
+	GRPCOption func(g *GRPCRedirector)
+
+	// GRPCConfig gRPC服务配置,接口定义见 grpcapi/hub.proto
+	GRPCConfig struct {
+		Port    int           `yaml:"port"`
+		Auth    bool          `yaml:"auth"`    // 是否需要认证,不认证时客户端拥有所有权限
+		Queue   int           `yaml:"queue"`   // 每个订阅的发送队列长度,满时结束订阅
+		Backlog time.Duration `yaml:"backlog"` // 积压消息的保留时间,重新订阅时补发,0为不保存
+	}
+
+	identityKey struct{}
+)
+
+func (c *GRPCConfig) Validate() error {
+	if !validPort(c.Port) {
+		return fmt.Errorf("port: 端口 %d 无效", c.Port)
+	}
+	if c.Queue < 1 {
+		return fmt.Errorf("queue: 不能小于1")
+	}
+	if c.Backlog < 0 {
+		return fmt.Errorf("backlog: 不能小于0")
+	}
+	return nil
+}
+
+func (c *GRPCConfig) Ports() []int {
+	return []int{c.Port}
+}
+
+func init() {
+	Register("grpc", func() *GRPCConfig {
+		return &GRPCConfig{Port: 9090, Auth: true, Queue: 100, Backlog: 24 * time.Hour}
+	}, func(env *Env, c *GRPCConfig) (MessageRedirector, error) {
+		backlog, err := newBacklog(env.Ctx, env.DB, env.Name, c.Backlog)
+		if err != nil {
+			return nil, err
+		}
+		options := []GRPCOption{GRPCQueue(c.Queue), grpcBacklog(backlog)}
+		if c.Auth {
+			options = append(options, GRPCAuth(env.Auth))
+		}
+		server := NewGRPCRedirector(env.Ctx, options...)
+		server.OnMessage(env.OnMessage)
+		go server.ListenAndServe(c.Port)
+		return server, nil
+	})
+}
+
+// GRPCQueue 每个订阅的发送队列长度
+func GRPCQueue(size int) GRPCOption {
+	return func(g *GRPCRedirector) {
+		if size < 1 {
+			size = 1
+		}
+		g.queueSize = size
+	}
+}
+
+func grpcBacklog(backlog *backlog) GRPCOption {
+	return func(g *GRPCRedirector) {
+		g.backlog = backlog
+	}
+}
+
+func GRPCAuth(manager *auth.Manager) GRPCOption {
+	return func(g *GRPCRedirector) {
+		g.auth = manager
+	}
+}
+
+func NewGRPCRedirector(ctx context.Context, options ...GRPCOption) *GRPCRedirector {
+	g := &GRPCRedirector{
+		fanout:    newFanout(ctx),
+		queueSize: 100,
+	}
+	for _, option := range options {
+		option(g)
+	}
+	g.server = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(g.unaryAuth),
+		grpc.ChainStreamInterceptor(g.streamAuth),
+	)
+	grpcapi.RegisterHubServer(g.server, g)
+	reflection.Register(g.server)
+	return g
+}
+
+func (g *GRPCRedirector) OnMessage(fn OnMessage) {
+	g.onMessage = fn
+}
+
+func (g *GRPCRedirector) ListenAndServe(port int) {
+	slog.Info("GRPCRedirector listening on", "port", port)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		slog.Error("ListenAndServe", "err", err)
+		return
+	}
+	go func() {
+		<-g.ctx.Done()
+		g.server.Stop()
+	}()
+	if err := g.server.Serve(listener); err != nil {
+		slog.Error("ListenAndServe", "err", err)
+	}
+}
+
+// authenticate 认证请求,metadata authorization 支持 Bearer 令牌及 basic-auth,未启用认证时返回nil
+func (g *GRPCRedirector) authenticate(ctx context.Context) (*auth.Identity, error) {
+	if g.auth == nil {
+		return nil, nil
+	}
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return g.auth.CheckToken(token)
+	}
+	if encoded, ok := strings.CutPrefix(authorization, "Basic "); ok {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return g.auth.Authenticate(username, password)
+	}
+	return nil, errors.New("缺少认证信息")
+}
+
+func (g *GRPCRedirector) unaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	identity, err := g.authenticate(ctx)
+	if err != nil {
+		slog.Error("GRPCRedirector 认证失败", "method", info.FullMethod, "err", err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(context.WithValue(ctx, identityKey{}, identity), req)
+}
+
+func (g *GRPCRedirector) streamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	identity, err := g.authenticate(ss.Context())
+	if err != nil {
+		slog.Error("GRPCRedirector 认证失败", "method", info.FullMethod, "err", err)
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(srv, &identityStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), identityKey{}, identity)})
+}
+
+// identityStream 携带认证身份的流
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+func identityFrom(ctx context.Context) *auth.Identity {
+	identity, _ := ctx.Value(identityKey{}).(*auth.Identity)
+	return identity
+}
+
+// command 组装命令交给hub执行,结果解析到result,执行结果的错误码转换为gRPC状态
+func (g *GRPCRedirector) command(ctx context.Context, name string, param, result any) error {
+	if g.onMessage == nil {
+		return status.Error(codes.Unavailable, "未设置命令处理")
+	}
+	raw, err := json.Marshal(param)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	payload, err := json.Marshal(hub.Command{ID: name, Command: name, Param: raw})
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	reply, err := g.onMessage(payload, "GRPC", identityFrom(ctx))
+	if len(reply) == 0 {
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		// 机器人未登录时不处理命令
+		return status.Error(codes.Unavailable, "机器人未登录")
+	}
+	var r struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(reply, &r); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch r.Code {
+	case 0:
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, r.Msg)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, r.Msg)
+	default:
+		return status.Error(codes.Internal, r.Msg)
+	}
+	if result != nil && len(r.Data) > 0 {
+		if err = json.Unmarshal(r.Data, result); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+func (g *GRPCRedirector) SendText(ctx context.Context, req *grpcapi.SendTextRequest) (*grpcapi.SendResult, error) {
+	var result hub.SendMsgResult
+	err := g.command(ctx, hub.CommandSendMessage, &hub.SendMsgCommand{
+		Gid:    req.GetGid(),
+		Uid:    req.GetUid(),
+		Type:   1,
+		Body:   req.GetContent(),
+		Prompt: req.GetPrompt(),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcapi.SendResult{MsgId: result.MsgID}, nil
+}
+
+// SendMedia 资源地址与文件内容二选一,文件内容以 BASE64: 前缀传给发送命令
+func (g *GRPCRedirector) SendMedia(ctx context.Context, req *grpcapi.SendMediaRequest) (*grpcapi.SendResult, error) {
+	switch req.GetType() {
+	case grpcapi.MediaType_MEDIA_TYPE_IMAGE, grpcapi.MediaType_MEDIA_TYPE_VIDEO, grpcapi.MediaType_MEDIA_TYPE_FILE:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "type: 不支持的类型 %s", req.GetType())
+	}
+	var body string
+	switch source := req.GetSource().(type) {
+	case *grpcapi.SendMediaRequest_Resource:
+		body = source.Resource
+	case *grpcapi.SendMediaRequest_Data:
+		body = "BASE64:" + base64.StdEncoding.EncodeToString(source.Data)
+	}
+	if body == "" {
+		return nil, status.Error(codes.InvalidArgument, "resource或data不能为空")
+	}
+	var result hub.SendMsgResult
+	err := g.command(ctx, hub.CommandSendMessage, &hub.SendMsgCommand{
+		Gid:      req.GetGid(),
+		Uid:      req.GetUid(),
+		Type:     int(req.GetType()),
+		Body:     body,
+		Filename: req.GetFilename(),
+		Prompt:   req.GetPrompt(),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcapi.SendResult{MsgId: result.MsgID}, nil
+}
+
+func (g *GRPCRedirector) Revoke(ctx context.Context, req *grpcapi.RevokeRequest) (*grpcapi.RevokeResult, error) {
+	if err := g.command(ctx, hub.CommandRevokeMessage, &hub.RevokeMsgCommand{MsgID: req.GetMsgId()}, nil); err != nil {
+		return nil, err
+	}
+	return &grpcapi.RevokeResult{}, nil
+}
+
+func (g *GRPCRedirector) ListGroups(ctx context.Context, _ *grpcapi.ListGroupsRequest) (*grpcapi.ListGroupsResult, error) {
+	var groups []hub.Group
+	if err := g.command(ctx, hub.CommandListGroups, struct{}{}, &groups); err != nil {
+		return nil, err
+	}
+	result := &grpcapi.ListGroupsResult{Groups: make([]*grpcapi.Group, len(groups))}
+	for i, group := range groups {
+		result.Groups[i] = &grpcapi.Group{Gid: group.GID, Name: group.Name, MemberCount: int32(group.MemberCount)}
+	}
+	return result, nil
+}
+
+func (g *GRPCRedirector) ListGroupMembers(ctx context.Context, req *grpcapi.ListGroupMembersRequest) (*grpcapi.ListGroupMembersResult, error) {
+	var members []hub.GroupUser
+	if err := g.command(ctx, hub.CommandListGroupMembers, &hub.GroupMembersCommand{Gid: req.GetGid()}, &members); err != nil {
+		return nil, err
+	}
+	result := &grpcapi.ListGroupMembersResult{Members: make([]*grpcapi.GroupUser, len(members))}
+	for i, member := range members {
+		result.Members[i] = &grpcapi.GroupUser{Gid: member.GID, Uid: member.UID, Nickname: member.Nickname, LeaveTime: member.LeaveTime}
+	}
+	return result, nil
+}
+
+// Subscribe 推送消息直到客户端取消,seq大于0时先补发之后的积压消息
+func (g *GRPCRedirector) Subscribe(req *grpcapi.SubscribeRequest, stream grpc.ServerStreamingServer[grpcapi.MessageEvent]) error {
+	ctx := stream.Context()
+	identity := identityFrom(ctx)
+	if !identity.Allow(auth.ScopeReadMessages) {
+		return status.Errorf(codes.PermissionDenied, "需要 %s 权限", auth.ScopeReadMessages)
+	}
+	filter := hub.SubscribeCommand{Groups: req.GetGroups(), Events: req.GetEvents()}
+	for _, t := range req.GetTypes() {
+		filter.Types = append(filter.Types, int(t))
+	}
+	for _, gid := range filter.Groups {
+		if !identity.AllowGroup(gid) {
+			return status.Errorf(codes.PermissionDenied, "没有群 %s 的权限", gid)
+		}
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	user := addr
+	if identity != nil {
+		user = identity.Username
+	}
+	var overflowed atomic.Bool
+	client := &streamClient{
+		info: &ClientInfo{
+			ID:          addr,
+			User:        user,
+			ConnectTime: time.Now().UnixMilli(),
+		},
+		identity: identity,
+		// 队列满时结束订阅,客户端重新订阅后补发
+		queue: newClientQueue(g.queueSize, OverflowDisconnect, "", 0),
+		disconnect: func(err error) {
+			if errors.Is(err, ErrQueueFull) {
+				overflowed.Store(true)
+			}
+		},
+	}
+	if filter.Validate() == nil {
+		client.subscriptions.add(filter)
+	}
+
+	resume := req.GetSeq() > 0 && g.backlog.persistent()
+	var result hub.ResumeResult
+	if resume {
+		var err error
+		if result, err = g.backlog.resumeResult(req.GetSeq()); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		// 补发的起始序号及缺失情况通过响应头返回
+		md := metadata.Pairs("resume-from", strconv.FormatInt(result.From, 10))
+		if result.Gap {
+			md.Append("resume-oldest", strconv.FormatInt(result.Oldest, 10))
+		}
+		if err = stream.SendHeader(md); err != nil {
+			return err
+		}
+		client.position.start()
+	}
+	g.add(client)
+	slog.Info("gRPC订阅连接", "client", addr, "user", user, "seq", req.GetSeq())
+	defer func() {
+		g.remove(client)
+		slog.Info("gRPC订阅断开", "client", addr, "user", user)
+	}()
+	if resume {
+		go g.resume(client, result.From)
+	}
+
+	for {
+		item, ok := client.queue.pop(ctx)
+		if !ok {
+			if overflowed.Load() {
+				return status.Error(codes.ResourceExhausted, ErrQueueFull.Error())
+			}
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		err := stream.Send(messageEvent(item.payload))
+		item.finish(err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// messageEvent 解析消息的路由字段,原始消息放在json字段
+func messageEvent(payload []byte) *grpcapi.MessageEvent {
+	header := parseHeader(payload)
+	return &grpcapi.MessageEvent{
+		Seq:     header.Seq,
+		MsgType: int32(header.MsgType),
+		MsgId:   header.MsgID,
+		Time:    header.Time,
+		Gid:     header.GID,
+		Uid:     header.UID,
+		Event:   header.Event,
+		Json:    payload,
+	}
+}
@@ -2,25 +2,121 @@ package redirect
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	authManager "wechat-hub/auth"
 
 	mqtt "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/hooks/storage/badger"
 	"github.com/mochi-mqtt/server/v2/listeners"
 	"github.com/mochi-mqtt/server/v2/packets"
+	"gorm.io/gorm"
 )
 
 type MQTTRedirector struct {
 	server         *mqtt.Server
+	replyClient    *mqtt.Client
+	dataDir        string
 	publishTopic   string
 	subscribeTopic string
-	auth           bool
+	responseTopic  string
+	stateTopic     string
+	auth           *UserAuthHook
 	onMessage      OnMessage
 }
 type MQTTOption = func(*MQTTRedirector)
 
+// MQTTConfig MQTT服务端配置
+type MQTTConfig struct {
+	Port           int    `yaml:"port"`
+	WSPort         int    `yaml:"wsPort"`  // websocket端口,为0时不启用
+	Store          string `yaml:"store"`   // 持久化方式,badger或db,为空时不持久化
+	DataDir        string `yaml:"dataDir"` // badger数据目录,默认为 {data}/{转发器名称小写}
+	Auth           bool   `yaml:"auth"`    // 是否需要认证,不认证时允许所有连接
+	PublishTopic   string `yaml:"publishTopic"`
+	SubscribeTopic string `yaml:"subscribeTopic"`
+	ResponseTopic  string `yaml:"responseTopic"`
+	StateTopic     string `yaml:"stateTopic"`
+}
+
+func (c *MQTTConfig) Validate() error {
+	if !validPort(c.Port) {
+		return fmt.Errorf("port: 端口 %d 无效", c.Port)
+	}
+	if c.WSPort != 0 && !validPort(c.WSPort) {
+		return fmt.Errorf("wsPort: 端口 %d 无效", c.WSPort)
+	}
+	switch c.Store {
+	case "", "badger", "db":
+	default:
+		return fmt.Errorf("store: 未知的持久化方式 %q,可选 badger、db", c.Store)
+	}
+	for _, topic := range [][2]string{
+		{"publishTopic", c.PublishTopic},
+		{"subscribeTopic", c.SubscribeTopic},
+		{"responseTopic", c.ResponseTopic},
+		{"stateTopic", c.StateTopic},
+	} {
+		if topic[1] == "" || strings.ContainsAny(topic[1], "+#") || strings.HasSuffix(topic[1], "/") {
+			return fmt.Errorf("%s: 主题 %q 无效,不能为空或包含通配符", topic[0], topic[1])
+		}
+	}
+	return nil
+}
+
+func (c *MQTTConfig) Ports() []int {
+	if c.WSPort != 0 {
+		return []int{c.Port, c.WSPort}
+	}
+	return []int{c.Port}
+}
+
+func init() {
+	Register("mqtt", func() *MQTTConfig {
+		return &MQTTConfig{
+			Port:           1883,
+			Auth:           true,
+			PublishTopic:   "message",
+			SubscribeTopic: "command",
+			ResponseTopic:  "reply",
+			StateTopic:     "state",
+		}
+	}, func(env *Env, c *MQTTConfig) (MessageRedirector, error) {
+		dataDir := c.DataDir
+		if dataDir == "" {
+			dataDir = path.Join(env.DataDir, strings.ToLower(env.Name))
+		}
+		options := []MQTTOption{
+			WithSubscribeTopic(c.SubscribeTopic),
+			WithResponseTopic(c.ResponseTopic),
+			WithStateTopic(c.StateTopic),
+			WithTCP(c.Port),
+		}
+		if c.Auth && env.Auth != nil {
+			options = append(options, WithMQTTAuth(env.Auth))
+		}
+		if c.WSPort > 0 {
+			options = append(options, WithWS(c.WSPort))
+		}
+		switch c.Store {
+		case "badger":
+			options = append(options, WithBadgerStore())
+		case "db":
+			options = append(options, WithGormStore(env.DB, env.Name))
+		}
+		server := NewMQTTServerMessageHandler(dataDir, c.PublishTopic, options...)
+		server.OnMessage(env.OnMessage)
+		go server.ListenAndServe()
+		return server, nil
+	})
+}
+
 func WithTCP(port int) MQTTOption {
 	return func(h *MQTTRedirector) {
 		_ = h.server.AddListener(listeners.NewTCP(listeners.Config{ID: fmt.Sprintf("tcp_%d", port), Address: fmt.Sprintf(":%d", port)}))
@@ -33,39 +129,80 @@ func WithWS(port int) MQTTOption {
 	}
 }
 
+// WithSubscribeTopic 命令主题,同时订阅 {topic} 及 {topic}/{gid},后者命令参数的群id以主题为准
 func WithSubscribeTopic(topic string) MQTTOption {
 	return func(h *MQTTRedirector) {
 		h.subscribeTopic = topic
 	}
 }
 
+// WithResponseTopic 命令结果的默认回复主题前缀,实际主题为 {topic}/{clientID}
+// MQTT5 客户端可通过 Response Topic 属性指定 {topic}/{clientID} 下的子主题
+func WithResponseTopic(topic string) MQTTOption {
+	return func(h *MQTTRedirector) {
+		h.responseTopic = topic
+	}
+}
+
+// WithStateTopic 状态主题前缀,状态以保留消息发布到 {topic}/{name}
+func WithStateTopic(topic string) MQTTOption {
+	return func(h *MQTTRedirector) {
+		h.stateTopic = topic
+	}
+}
+
+// WithBadgerStore 使用badger持久化会话、订阅及保留消息,数据保存在dataDir
+func WithBadgerStore() MQTTOption {
+	return func(h *MQTTRedirector) {
+		if err := h.server.AddHook(new(badger.Hook), &badger.Options{
+			Path: h.dataDir,
+		}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// WithGormStore 使用数据库持久化会话、订阅及保留消息,stream区分不同转发器的数据
+func WithGormStore(db *gorm.DB, stream string) MQTTOption {
+	return func(h *MQTTRedirector) {
+		if err := h.server.AddHook(new(GormStoreHook), &gormStoreHookOption{
+			db:     db,
+			stream: stream,
+		}); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func WithMQTTAuth(manager *authManager.Manager) MQTTOption {
 	return func(h *MQTTRedirector) {
-		h.auth = true
-		_ = h.server.AddHook(new(UserAuthHook), &userAuthHookOption{
-			auth: manager,
+		h.auth = new(UserAuthHook)
+		_ = h.server.AddHook(h.auth, &userAuthHookOption{
+			auth:       manager,
+			redirector: h,
 		})
 	}
 }
 
+// NewMQTTServerMessageHandler 消息发布到 {publishTopic}/{gid或uid}/{msgType},客户端可按群及消息类型通配订阅
 func NewMQTTServerMessageHandler(dataDir string, publishTopic string, options ...MQTTOption) *MQTTRedirector {
 	server := mqtt.New(&mqtt.Options{
 		InlineClient: true, // 开启内联客户端
 	})
-	// // 消息持久化
-	// if err := server.AddHook(new(badger.Hook), &badger.Options{
-	// 	Path: dataDir,
-	// }); err != nil {
-	// 	panic(err)
-	// }
+	replyClient := server.NewClient(nil, "local", "reply", true)
+	replyClient.Properties.ProtocolVersion = 5
 	h := &MQTTRedirector{
-		server:       server,
-		publishTopic: publishTopic,
+		server:        server,
+		replyClient:   replyClient,
+		dataDir:       dataDir,
+		publishTopic:  publishTopic,
+		responseTopic: "reply",
+		stateTopic:    "state",
 	}
 	for _, option := range options {
 		option(h)
 	}
-	if !h.auth {
+	if h.auth == nil {
 		// 允许所有连接
 		_ = server.AddHook(new(auth.AllowHook), nil)
 	}
@@ -74,12 +211,22 @@ func NewMQTTServerMessageHandler(dataDir string, publishTopic string, options ..
 
 func (h *MQTTRedirector) ListenAndServe() {
 	if h.subscribeTopic != "" {
-		if err := h.server.Subscribe(h.subscribeTopic, 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
-			if h.onMessage != nil {
-				_ = h.onMessage(pk.Payload, "MQTT", string(cl.Properties.Username))
+		handler := func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+			if h.onMessage == nil {
+				return
+			}
+			payload := pk.Payload
+			if gid := h.topicGid(pk.TopicName); gid != "" {
+				payload = withGid(payload, gid)
+			}
+			if reply, _ := h.onMessage(payload, "MQTT", h.identity(cl)); reply != nil {
+				h.reply(cl, pk, reply)
+			}
+		}
+		for i, filter := range []string{h.subscribeTopic, h.subscribeTopic + "/#"} {
+			if err := h.server.Subscribe(filter, i+1, handler); err != nil {
+				panic(err)
 			}
-		}); err != nil {
-			panic(err)
 		}
 	}
 	slog.Info("MQTTServerMessageHandler serving")
@@ -88,10 +235,104 @@ func (h *MQTTRedirector) ListenAndServe() {
 	}
 }
 
+// reply 回复命令结果并携带 Correlation Data,请求中的 Response Topic 只能是 {responseTopic}/{clientID} 下的主题,
+// 回复由内联客户端发布不经过权限检查,不能让客户端借此向消息、状态等主题发布
+func (h *MQTTRedirector) reply(cl *mqtt.Client, request packets.Packet, payload []byte) {
+	topic := h.responseTopic + "/" + cl.ID
+	if requested := request.Properties.ResponseTopic; requested != "" {
+		if (requested == topic || strings.HasPrefix(requested, topic+"/")) && !strings.ContainsAny(requested, "+#") {
+			topic = requested
+		} else {
+			slog.Warn("MQTT回复主题不允许,使用默认主题", "requested", requested, "topic", topic, "client", cl.ID)
+		}
+	}
+	err := h.server.InjectPacket(h.replyClient, packets.Packet{
+		FixedHeader: packets.FixedHeader{
+			Type: packets.Publish,
+			Qos:  1,
+		},
+		TopicName: topic,
+		Payload:   payload,
+		Properties: packets.Properties{
+			CorrelationData: request.Properties.CorrelationData,
+		},
+		PacketID: 1,
+	})
+	if err != nil {
+		slog.Error("MQTT回复消息失败", "topic", topic, "client", cl.ID, "err", err)
+	}
+}
+
+// identity 客户端认证后的身份,未开启认证时为nil
+func (h *MQTTRedirector) identity(cl *mqtt.Client) *authManager.Identity {
+	if h.auth == nil {
+		return nil
+	}
+	if identity, ok := h.auth.identities.Load(cl.ID); ok {
+		return identity.(*authManager.Identity)
+	}
+	// 持久会话恢复的离线客户端没有认证信息,重新连接认证前没有任何权限
+	return &authManager.Identity{Username: string(cl.Properties.Username), Scopes: []string{}, Groups: []string{}}
+}
+
+// topicGid 从 {subscribeTopic}/{gid} 主题中取出群id
+func (h *MQTTRedirector) topicGid(topic string) string {
+	gid, ok := strings.CutPrefix(topic, h.subscribeTopic+"/")
+	if !ok {
+		return ""
+	}
+	gid, _, _ = strings.Cut(gid, "/")
+	return gid
+}
+
+// withGid 使用主题中的群id覆盖命令参数,保证按主题授权的用户只能操作对应的群
+func withGid(payload []byte, gid string) []byte {
+	var command map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &command); err != nil {
+		return payload
+	}
+	param := map[string]any{}
+	if raw, ok := command["param"]; ok && len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &param); err != nil {
+			return payload
+		}
+	}
+	param["gid"] = gid
+	raw, err := json.Marshal(param)
+	if err != nil {
+		return payload
+	}
+	command["param"] = raw
+	if marshal, err := json.Marshal(command); err == nil {
+		return marshal
+	}
+	return payload
+}
+
+// messageTopic 按会话及消息类型生成发布主题
+func (h *MQTTRedirector) messageTopic(payload []byte) string {
+	// 群消息使用群id,私聊消息使用好友id
+	header := parseHeader(payload)
+	chat := header.GID
+	if chat == "" {
+		chat = header.UID
+	}
+	if chat == "" {
+		return h.publishTopic
+	}
+	return h.publishTopic + "/" + chat + "/" + strconv.Itoa(header.MsgType)
+}
+
+// SendMessage 以QoS1发布,订阅的会话由服务端保存待确认的消息,发布失败时由投递箱稍后重试
 func (h *MQTTRedirector) SendMessage(bytes []byte) error {
-	_ = h.server.Publish(h.publishTopic, bytes, false, 1)
-	return nil
+	return h.server.Publish(h.messageTopic(bytes), bytes, false, 1)
 }
+
+// PublishState 以保留消息发布状态,新订阅的客户端可以立即收到最新状态
+func (h *MQTTRedirector) PublishState(name string, bytes []byte) error {
+	return h.server.Publish(h.stateTopic+"/"+name, bytes, true, 1)
+}
+
 func (h *MQTTRedirector) OnMessage(fn OnMessage) {
 	h.onMessage = fn
 
@@ -100,10 +341,13 @@ func (h *MQTTRedirector) OnMessage(fn OnMessage) {
 type (
 	UserAuthHook struct {
 		mqtt.HookBase
-		auth *authManager.Manager
+		auth       *authManager.Manager
+		redirector *MQTTRedirector
+		identities sync.Map // 客户端id -> *authManager.Identity
 	}
 	userAuthHookOption struct {
-		auth *authManager.Manager
+		auth       *authManager.Manager
+		redirector *MQTTRedirector
 	}
 )
 
@@ -115,6 +359,7 @@ func (h *UserAuthHook) Provides(b byte) bool {
 	return bytes.Contains([]byte{
 		mqtt.OnConnectAuthenticate,
 		mqtt.OnACLCheck,
+		mqtt.OnDisconnect,
 	}, []byte{b})
 }
 
@@ -123,20 +368,67 @@ func (h *UserAuthHook) Init(config any) error {
 		return mqtt.ErrInvalidConfigType
 	} else {
 		h.auth = cfg.auth
+		h.redirector = cfg.redirector
 	}
 	return nil
 }
 
+// OnACLCheck 订阅时检查主题过滤器,投递及发布时检查实际主题,客户端总是可以订阅自己的回复主题
 func (h *UserAuthHook) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
-	return true
+	if !write && h.redirector != nil && topic == h.redirector.responseTopic+"/"+cl.ID {
+		return true
+	}
+	username := string(cl.Properties.Username)
+	identity := h.redirector.identity(cl)
+	// 订阅及接收消息需要接收消息权限,发布命令的权限在执行命令时检查
+	if (write || identity.Allow(authManager.ScopeReadMessages)) && h.allowTopicGroup(identity, topic) && h.auth.CheckACL(username, topic, write) {
+		return true
+	}
+	if write {
+		slog.Warn("MQTTServerMessageHandler OnACLCheck denied", "username", username, "client", cl.ID, "topic", topic)
+	} else {
+		// 投递消息时每条都会检查,不需要每次都告警
+		slog.Debug("MQTTServerMessageHandler OnACLCheck denied", "username", username, "client", cl.ID, "topic", topic)
+	}
+	return false
 }
+
+// allowTopicGroup 按群授权检查消息及命令主题,通配的层级在投递时按实际主题检查
+func (h *UserAuthHook) allowTopicGroup(identity *authManager.Identity, topic string) bool {
+	if identity == nil || identity.Groups == nil {
+		return true
+	}
+	r := h.redirector
+	for _, prefix := range []string{r.publishTopic, r.subscribeTopic} {
+		if prefix == "" {
+			continue
+		}
+		if chat, ok := strings.CutPrefix(topic, prefix+"/"); ok {
+			chat, _, _ = strings.Cut(chat, "/")
+			return chat == "+" || chat == "#" || identity.AllowGroup(chat)
+		}
+	}
+	// 群列表包含所有群,受群限制的用户不能获取
+	return topic != r.stateTopic+"/groups"
+}
+
+// OnConnectAuthenticate 密码可以是用户的令牌,用户名为空时使用令牌所属用户
 func (h *UserAuthHook) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
 	username := string(pk.Connect.Username)
-	authed := h.auth.CheckUser(username, string(pk.Connect.Password))
-	if !authed {
-		slog.Error("MQTTServerMessageHandler OnConnectAuthenticate", "username", username, "password", string(pk.Connect.Password), "err", "auth failed")
-	} else {
-		slog.Info("MQTTServerMessageHandler OnConnectAuthenticate", "username", username, "password", string(pk.Connect.Password))
+	identity, err := h.auth.Authenticate(username, string(pk.Connect.Password))
+	if err != nil {
+		slog.Error("MQTTServerMessageHandler OnConnectAuthenticate", "username", username, "err", err)
+		return false
+	}
+	cl.Properties.Username = []byte(identity.Username)
+	h.identities.Store(cl.ID, identity)
+	slog.Info("MQTTServerMessageHandler OnConnectAuthenticate", "username", identity.Username, "scopes", identity.Scopes)
+	return true
+}
+
+func (h *UserAuthHook) OnDisconnect(cl *mqtt.Client, _ error, _ bool) {
+	// 会话被接管时新连接已经写入身份
+	if cl.StopCause() != packets.ErrSessionTakenOver {
+		h.identities.Delete(cl.ID)
 	}
-	return authed
 }
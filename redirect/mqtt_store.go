@@ -0,0 +1,290 @@
+package redirect
+
+import (
+	"bytes"
+	"log/slog"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/mochi-mqtt/server/v2/system"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	// GormStoreHook 使用数据库持久化MQTT会话、订阅、保留消息及未确认消息,
+	// 多个MQTT转发器共用一张表,按转发器名称区分
+	GormStoreHook struct {
+		mqtt.HookBase
+		db     *gorm.DB
+		stream string
+	}
+	gormStoreHookOption struct {
+		db     *gorm.DB
+		stream string
+	}
+
+	mqttStore struct {
+		Stream string `gorm:"primaryKey;size:100"`
+		ID     string `gorm:"primaryKey;size:512"`
+		Kind   string `gorm:"index;size:20;not null"`
+		Value  []byte `gorm:"not null"`
+	}
+)
+
+func (mqttStore) TableName() string {
+	return "mqtt_store"
+}
+
+func clientKey(cl *mqtt.Client) string {
+	return storage.ClientKey + "_" + cl.ID
+}
+
+func subscriptionKey(cl *mqtt.Client, filter string) string {
+	return storage.SubscriptionKey + "_" + cl.ID + ":" + filter
+}
+
+func retainedKey(topic string) string {
+	return storage.RetainedKey + "_" + topic
+}
+
+func inflightKey(cl *mqtt.Client, pk packets.Packet) string {
+	return storage.InflightKey + "_" + cl.ID + ":" + pk.FormatID()
+}
+
+func (h *GormStoreHook) ID() string {
+	return "gorm-store"
+}
+
+func (h *GormStoreHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnSessionEstablished,
+		mqtt.OnDisconnect,
+		mqtt.OnSubscribed,
+		mqtt.OnUnsubscribed,
+		mqtt.OnRetainMessage,
+		mqtt.OnWillSent,
+		mqtt.OnQosPublish,
+		mqtt.OnQosComplete,
+		mqtt.OnQosDropped,
+		mqtt.OnSysInfoTick,
+		mqtt.OnClientExpired,
+		mqtt.OnRetainedExpired,
+		mqtt.StoredClients,
+		mqtt.StoredInflightMessages,
+		mqtt.StoredRetainedMessages,
+		mqtt.StoredSubscriptions,
+		mqtt.StoredSysInfo,
+	}, []byte{b})
+}
+
+func (h *GormStoreHook) Init(config any) error {
+	cfg, ok := config.(*gormStoreHookOption)
+	if !ok || cfg.db == nil {
+		return mqtt.ErrInvalidConfigType
+	}
+	h.db = cfg.db
+	h.stream = cfg.stream
+	return h.db.AutoMigrate(&mqttStore{})
+}
+
+func (h *GormStoreHook) OnSessionEstablished(cl *mqtt.Client, pk packets.Packet) {
+	h.updateClient(cl)
+}
+
+func (h *GormStoreHook) OnWillSent(cl *mqtt.Client, pk packets.Packet) {
+	h.updateClient(cl)
+}
+
+func (h *GormStoreHook) updateClient(cl *mqtt.Client) {
+	props := cl.Properties.Props.Copy(false)
+	h.set(clientKey(cl), storage.ClientKey, &storage.Client{
+		ID:              cl.ID,
+		T:               storage.ClientKey,
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		Username:        cl.Properties.Username,
+		Clean:           cl.Properties.Clean,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Properties: storage.ClientProperties{
+			SessionExpiryInterval: props.SessionExpiryInterval,
+			AuthenticationMethod:  props.AuthenticationMethod,
+			AuthenticationData:    props.AuthenticationData,
+			RequestProblemInfo:    props.RequestProblemInfo,
+			RequestResponseInfo:   props.RequestResponseInfo,
+			ReceiveMaximum:        props.ReceiveMaximum,
+			TopicAliasMaximum:     props.TopicAliasMaximum,
+			User:                  props.User,
+			MaximumPacketSize:     props.MaximumPacketSize,
+		},
+		Will: storage.ClientWill(cl.Properties.Will),
+	})
+}
+
+// OnDisconnect 会话过期时删除客户端,会话被接管时保留
+func (h *GormStoreHook) OnDisconnect(cl *mqtt.Client, _ error, expire bool) {
+	if !expire || cl.StopCause() == packets.ErrSessionTakenOver {
+		return
+	}
+	h.del(clientKey(cl))
+}
+
+func (h *GormStoreHook) OnSubscribed(cl *mqtt.Client, pk packets.Packet, reasonCodes []byte) {
+	for i := 0; i < len(pk.Filters); i++ {
+		key := subscriptionKey(cl, pk.Filters[i].Filter)
+		h.set(key, storage.SubscriptionKey, &storage.Subscription{
+			ID:                key,
+			T:                 storage.SubscriptionKey,
+			Client:            cl.ID,
+			Qos:               reasonCodes[i],
+			Filter:            pk.Filters[i].Filter,
+			Identifier:        pk.Filters[i].Identifier,
+			NoLocal:           pk.Filters[i].NoLocal,
+			RetainHandling:    pk.Filters[i].RetainHandling,
+			RetainAsPublished: pk.Filters[i].RetainAsPublished,
+		})
+	}
+}
+
+func (h *GormStoreHook) OnUnsubscribed(cl *mqtt.Client, pk packets.Packet) {
+	for i := 0; i < len(pk.Filters); i++ {
+		h.del(subscriptionKey(cl, pk.Filters[i].Filter))
+	}
+}
+
+// OnRetainMessage 保存保留消息,r为-1时表示清除
+func (h *GormStoreHook) OnRetainMessage(cl *mqtt.Client, pk packets.Packet, r int64) {
+	if r == -1 {
+		h.del(retainedKey(pk.TopicName))
+		return
+	}
+	message := storedMessage(pk)
+	message.ID = retainedKey(pk.TopicName)
+	message.T = storage.RetainedKey
+	message.Client = cl.ID
+	h.set(message.ID, storage.RetainedKey, message)
+}
+
+func (h *GormStoreHook) OnQosPublish(cl *mqtt.Client, pk packets.Packet, sent int64, resends int) {
+	message := storedMessage(pk)
+	message.ID = inflightKey(cl, pk)
+	message.T = storage.InflightKey
+	message.Client = cl.ID
+	message.Sent = sent
+	h.set(message.ID, storage.InflightKey, message)
+}
+
+func (h *GormStoreHook) OnQosComplete(cl *mqtt.Client, pk packets.Packet) {
+	h.del(inflightKey(cl, pk))
+}
+
+func (h *GormStoreHook) OnQosDropped(cl *mqtt.Client, pk packets.Packet) {
+	h.OnQosComplete(cl, pk)
+}
+
+func (h *GormStoreHook) OnSysInfoTick(sys *system.Info) {
+	h.set(storage.SysInfoKey, storage.SysInfoKey, &storage.SystemInfo{
+		ID:   storage.SysInfoKey,
+		T:    storage.SysInfoKey,
+		Info: *sys.Clone(),
+	})
+}
+
+func (h *GormStoreHook) OnRetainedExpired(filter string) {
+	h.del(retainedKey(filter))
+}
+
+func (h *GormStoreHook) OnClientExpired(cl *mqtt.Client) {
+	h.del(clientKey(cl))
+}
+
+func (h *GormStoreHook) StoredClients() ([]storage.Client, error) {
+	return loadStored[storage.Client](h.scope(), storage.ClientKey)
+}
+
+func (h *GormStoreHook) StoredSubscriptions() ([]storage.Subscription, error) {
+	return loadStored[storage.Subscription](h.scope(), storage.SubscriptionKey)
+}
+
+func (h *GormStoreHook) StoredRetainedMessages() ([]storage.Message, error) {
+	return loadStored[storage.Message](h.scope(), storage.RetainedKey)
+}
+
+func (h *GormStoreHook) StoredInflightMessages() ([]storage.Message, error) {
+	return loadStored[storage.Message](h.scope(), storage.InflightKey)
+}
+
+func (h *GormStoreHook) StoredSysInfo() (v storage.SystemInfo, err error) {
+	var rows []mqttStore
+	if err = h.scope().Where("id = ?", storage.SysInfoKey).Limit(1).Find(&rows).Error; err != nil || len(rows) == 0 {
+		return v, err
+	}
+	err = v.UnmarshalBinary(rows[0].Value)
+	return v, err
+}
+
+func (h *GormStoreHook) set(key string, kind string, value storage.Serializable) {
+	data, err := value.MarshalBinary()
+	if err != nil {
+		slog.Error("MQTT持久化数据序列化失败", "key", key, "err", err)
+		return
+	}
+	err = h.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&mqttStore{Stream: h.stream, ID: key, Kind: kind, Value: data}).Error
+	if err != nil {
+		slog.Error("MQTT持久化数据保存失败", "key", key, "err", err)
+	}
+}
+
+func (h *GormStoreHook) del(key string) {
+	if err := h.scope().Delete(&mqttStore{}, "id = ?", key).Error; err != nil {
+		slog.Error("MQTT持久化数据删除失败", "key", key, "err", err)
+	}
+}
+
+// scope 只操作当前转发器的数据
+func (h *GormStoreHook) scope() *gorm.DB {
+	return h.db.Where("stream = ?", h.stream)
+}
+
+func storedMessage(pk packets.Packet) *storage.Message {
+	props := pk.Properties.Copy(false)
+	return &storage.Message{
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     pk.Payload,
+		Created:     pk.Created,
+		Origin:      pk.Origin,
+		Properties: storage.MessageProperties{
+			PayloadFormat:          props.PayloadFormat,
+			MessageExpiryInterval:  props.MessageExpiryInterval,
+			ContentType:            props.ContentType,
+			ResponseTopic:          props.ResponseTopic,
+			CorrelationData:        props.CorrelationData,
+			SubscriptionIdentifier: props.SubscriptionIdentifier,
+			TopicAlias:             props.TopicAlias,
+			User:                   props.User,
+		},
+	}
+}
+
+// loadStored 读取某一类持久化数据
+func loadStored[T any, P interface {
+	*T
+	UnmarshalBinary([]byte) error
+}](db *gorm.DB, kind string) ([]T, error) {
+	var rows []mqttStore
+	if err := db.Where("kind = ?", kind).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	values := make([]T, 0, len(rows))
+	for _, row := range rows {
+		var value T
+		if err := P(&value).UnmarshalBinary(row.Value); err != nil {
+			slog.Error("MQTT持久化数据解析失败", "key", row.ID, "err", err)
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
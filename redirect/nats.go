@@ -0,0 +1,401 @@
+package redirect
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"wechat-hub/auth"
+	"wechat-hub/hub"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+type (
+	// NATSRedirector 消息发布到 {subject}.{gid或uid},命令通过 {command} 请求/回复处理
+	// 未配置外部服务地址时在进程内启动NATS服务
+	NATSRedirector struct {
+		ctx            context.Context
+		url            string
+		port           int
+		connectOptions []nats.Option
+		subject        string
+		commandSubject string
+		auth           *auth.Manager
+		server         *server.Server
+		internal       string // 内嵌服务中转发器自身连接使用的令牌
+		conn           atomic.Pointer[nats.Conn]
+		onMessage      OnMessage
+	}
+
+	NATSOption func(n *NATSRedirector)
+
+	// NATSConfig NATS配置,url为空时启动内嵌服务
+	NATSConfig struct {
+		URL      string `yaml:"url"`      // 外部NATS服务地址
+		Username string `yaml:"username"` // 连接外部服务的用户名
+		Password string `yaml:"password"`
+		Token    string `yaml:"token"`   // 连接外部服务的令牌
+		Port     int    `yaml:"port"`    // 内嵌服务的端口
+		Auth     bool   `yaml:"auth"`    // 是否需要认证,不认证时命令拥有所有权限
+		Subject  string `yaml:"subject"` // 消息主题前缀
+		Command  string `yaml:"command"` // 命令主题
+	}
+
+	// natsAuth 内嵌服务使用系统用户认证连接,按权限限制可以订阅的消息主题
+	natsAuth struct {
+		manager  *auth.Manager
+		internal string // 转发器自身连接使用的令牌
+		subject  string
+		command  string
+	}
+)
+
+func (c *NATSConfig) Validate() error {
+	if c.URL != "" {
+		for _, u := range strings.Split(c.URL, ",") {
+			if err := checkURL(strings.TrimSpace(u), "nats", "tls", "ws", "wss"); err != nil {
+				return fmt.Errorf("url: %w", err)
+			}
+		}
+	} else if !validPort(c.Port) {
+		return fmt.Errorf("port: 端口 %d 无效", c.Port)
+	}
+	for _, subject := range [][2]string{
+		{"subject", c.Subject},
+		{"command", c.Command},
+	} {
+		if !validSubject(subject[1]) {
+			return fmt.Errorf("%s: 主题 %q 无效,不能为空或包含通配符", subject[0], subject[1])
+		}
+	}
+	return nil
+}
+
+// Ports 只有内嵌服务需要监听端口
+func (c *NATSConfig) Ports() []int {
+	if c.URL != "" {
+		return nil
+	}
+	return []int{c.Port}
+}
+
+func init() {
+	Register("nats", func() *NATSConfig {
+		return &NATSConfig{Port: 4222, Auth: true, Subject: "wechat.message", Command: "wechat.command"}
+	}, func(env *Env, c *NATSConfig) (MessageRedirector, error) {
+		options := []NATSOption{NATSSubject(c.Subject), NATSCommand(c.Command)}
+		if c.URL != "" {
+			options = append(options, NATSURL(c.URL, c.Username, c.Password, c.Token))
+		} else {
+			options = append(options, NATSEmbedded(c.Port))
+		}
+		if c.Auth {
+			options = append(options, NATSAuth(env.Auth))
+		}
+		n := NewNATSRedirector(env.Ctx, options...)
+		n.OnMessage(env.OnMessage)
+		if err := n.Start(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	})
+}
+
+// NATSURL 连接外部服务,多个地址用逗号分隔,username、password、token为空时不认证
+func NATSURL(url, username, password, token string) NATSOption {
+	return func(n *NATSRedirector) {
+		n.url = url
+		if username != "" {
+			n.connectOptions = append(n.connectOptions, nats.UserInfo(username, password))
+		}
+		if token != "" {
+			n.connectOptions = append(n.connectOptions, nats.Token(token))
+		}
+	}
+}
+
+// NATSEmbedded 在进程内启动NATS服务,客户端连接port
+func NATSEmbedded(port int) NATSOption {
+	return func(n *NATSRedirector) {
+		n.url = ""
+		n.port = port
+	}
+}
+
+// NATSSubject 消息主题前缀
+func NATSSubject(subject string) NATSOption {
+	return func(n *NATSRedirector) {
+		n.subject = subject
+	}
+}
+
+// NATSCommand 命令主题,同时订阅 {command}.{gid},后者命令参数的群id以主题为准
+func NATSCommand(subject string) NATSOption {
+	return func(n *NATSRedirector) {
+		n.commandSubject = subject
+	}
+}
+
+// NATSAuth 命令请求需要在消息头 Authorization 中携带 Bearer 令牌或 basic-auth,
+// 内嵌服务同时使用系统用户认证连接
+func NATSAuth(manager *auth.Manager) NATSOption {
+	return func(n *NATSRedirector) {
+		n.auth = manager
+	}
+}
+
+func NewNATSRedirector(ctx context.Context, options ...NATSOption) *NATSRedirector {
+	n := &NATSRedirector{
+		ctx:            ctx,
+		port:           4222,
+		subject:        "wechat.message",
+		commandSubject: "wechat.command",
+	}
+	for _, option := range options {
+		option(n)
+	}
+	return n
+}
+
+func (n *NATSRedirector) OnMessage(fn OnMessage) {
+	n.onMessage = fn
+}
+
+// Start 启动内嵌服务并连接,外部服务不可用时在后台重连
+func (n *NATSRedirector) Start() error {
+	options := append([]nats.Option{
+		nats.Name("wechat-hub"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("NATS连接断开", "err", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			slog.Info("NATS重新连接", "url", conn.ConnectedUrl())
+		}),
+	}, n.connectOptions...)
+	url := n.url
+	if url == "" {
+		if err := n.serve(); err != nil {
+			return err
+		}
+		url = n.server.ClientURL()
+		options = append(options, nats.InProcessServer(n.server))
+		if n.auth != nil {
+			options = append(options, nats.Token(n.internal))
+		}
+	}
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return fmt.Errorf("连接NATS失败: %w", err)
+	}
+	for _, subject := range []string{n.commandSubject, n.commandSubject + ".*"} {
+		if _, err = conn.Subscribe(subject, n.handle); err != nil {
+			conn.Close()
+			return fmt.Errorf("订阅NATS命令主题失败: %w", err)
+		}
+	}
+	n.conn.Store(conn)
+	slog.Info("NATSRedirector connected", "url", url, "subject", n.subject, "command", n.commandSubject)
+	go func() {
+		<-n.ctx.Done()
+		_ = conn.Drain()
+		if n.server != nil {
+			n.server.Shutdown()
+		}
+	}()
+	return nil
+}
+
+// serve 启动内嵌服务
+func (n *NATSRedirector) serve() error {
+	options := &server.Options{
+		ServerName: "wechat-hub",
+		Port:       n.port,
+		NoSigs:     true,
+	}
+	if n.auth != nil {
+		n.internal = nuid.Next()
+		options.CustomClientAuthentication = &natsAuth{
+			manager:  n.auth,
+			internal: n.internal,
+			subject:  n.subject,
+			command:  n.commandSubject,
+		}
+	}
+	s, err := server.NewServer(options)
+	if err != nil {
+		return fmt.Errorf("创建NATS服务失败: %w", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		return fmt.Errorf("NATS服务端口 %d 启动失败", n.port)
+	}
+	n.server = s
+	slog.Info("NATSRedirector listening on", "port", n.port)
+	return nil
+}
+
+// handle 处理命令请求,请求带有回复主题时回复执行结果
+func (n *NATSRedirector) handle(msg *nats.Msg) {
+	if n.onMessage == nil {
+		return
+	}
+	payload := msg.Data
+	if gid, ok := strings.CutPrefix(msg.Subject, n.commandSubject+"."); ok {
+		payload = withGid(payload, gid)
+	}
+	identity, err := n.authenticate(msg)
+	var reply []byte
+	if err != nil {
+		slog.Error("NATS命令认证失败", "subject", msg.Subject, "err", err)
+		reply = unauthorized(payload, err)
+	} else {
+		reply, _ = n.onMessage(payload, "NATS", identity)
+	}
+	if reply != nil && msg.Reply != "" {
+		if err = msg.Respond(reply); err != nil {
+			slog.Error("NATS回复消息失败", "subject", msg.Reply, "err", err)
+		}
+	}
+}
+
+// authenticate 认证命令请求,未开启认证时返回nil
+func (n *NATSRedirector) authenticate(msg *nats.Msg) (*auth.Identity, error) {
+	if n.auth == nil {
+		return nil, nil
+	}
+	authorization := msg.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return n.auth.CheckToken(token)
+	}
+	if encoded, ok := strings.CutPrefix(authorization, "Basic "); ok {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return n.auth.Authenticate(username, password)
+	}
+	return nil, errors.New("缺少认证信息")
+}
+
+// unauthorized 认证失败的命令结果
+func unauthorized(payload []byte, err error) []byte {
+	var command hub.Command
+	_ = json.Unmarshal(payload, &command)
+	reply, _ := json.Marshal(hub.CommandResult{
+		ID:      command.ID,
+		Command: command.Command,
+		Code:    http.StatusUnauthorized,
+		Msg:     err.Error(),
+	})
+	return reply
+}
+
+var ErrNATSDisconnected = errors.New("NATS未连接")
+
+func (n *NATSRedirector) SendMessage(bytes []byte) error {
+	conn := n.conn.Load()
+	if conn == nil {
+		return ErrNATSDisconnected
+	}
+	// 重连期间的消息由客户端缓冲,缓冲满时返回错误由投递箱稍后重试
+	return conn.Publish(n.messageSubject(bytes), bytes)
+}
+
+// messageSubject 群消息使用群id,私聊消息使用好友id
+func (n *NATSRedirector) messageSubject(payload []byte) string {
+	header := parseHeader(payload)
+	chat := header.GID
+	if chat == "" {
+		chat = header.UID
+	}
+	if chat = subjectToken(chat); chat == "" {
+		return n.subject
+	}
+	return n.subject + "." + chat
+}
+
+// subjectToken 替换主题中不允许出现的字符
+func subjectToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// natsInboxPrefix 用户的收件箱前缀 _INBOX_{用户名的十六进制},内嵌服务开启认证时客户端需要使用 nats.CustomInboxPrefix 设置,
+// 不能订阅公共的 _INBOX.> 接收其他用户的回复。用户名编码后不会像 subjectToken 那样让 a.b 和 a_b 共用收件箱
+func natsInboxPrefix(username string) string {
+	return "_INBOX_" + hex.EncodeToString([]byte(username))
+}
+
+func validSubject(subject string) bool {
+	if subject == "" || strings.ContainsAny(subject, "*> \t\r\n") {
+		return false
+	}
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Check 认证内嵌服务的连接,密码可以是用户的令牌,用户名为空时使用令牌所属用户
+func (a *natsAuth) Check(c server.ClientAuthentication) bool {
+	opts := c.GetOpts()
+	if opts.Token == a.internal {
+		c.RegisterUser(&server.User{Username: "wechat-hub"})
+		return true
+	}
+	var identity *auth.Identity
+	var err error
+	if opts.Token != "" {
+		identity, err = a.manager.CheckToken(opts.Token)
+	} else {
+		identity, err = a.manager.Authenticate(opts.Username, opts.Password)
+	}
+	if err != nil {
+		slog.Error("NATS连接认证失败", "username", opts.Username, "addr", c.RemoteAddress(), "err", err)
+		return false
+	}
+	c.RegisterUser(&server.User{Username: identity.Username, Permissions: a.permissions(identity)})
+	slog.Info("NATS连接认证", "username", identity.Username, "scopes", identity.Scopes)
+	return true
+}
+
+// permissions 所有用户都可以发送命令,回复只能通过用户自己的收件箱 {natsInboxPrefix(用户名)}.> 接收,
+// 订阅消息需要接收消息权限,受群限制的用户只能订阅授权群的主题
+func (a *natsAuth) permissions(identity *auth.Identity) *server.Permissions {
+	subscribe := []string{natsInboxPrefix(identity.Username) + ".>"}
+	if identity.Allow(auth.ScopeReadMessages) {
+		if identity.Groups == nil {
+			subscribe = append(subscribe, a.subject, a.subject+".>")
+		}
+		for _, gid := range identity.Groups {
+			subscribe = append(subscribe, a.subject+"."+subjectToken(gid))
+		}
+	}
+	return &server.Permissions{
+		Publish:   &server.SubjectPermission{Allow: []string{a.command, a.command + ".*"}},
+		Subscribe: &server.SubjectPermission{Allow: subscribe},
+	}
+}
@@ -0,0 +1,356 @@
+package redirect
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 发送队列满时的处理方式
+const (
+	OverflowDropOldest = "dropOldest" // 丢弃最早的消息
+	OverflowDisconnect = "disconnect" // 断开连接
+	OverflowSpill      = "spill"      // 溢出到磁盘,客户端追上后再发送
+)
+
+// spillCompact 溢出文件已读部分超过该大小且不少于未读部分时压缩文件,避免一直未追上的客户端文件无限增长
+const spillCompact = 4 << 20
+
+var (
+	ErrQueueFull   = errors.New("发送队列已满")
+	errQueueClosed = errors.New("发送队列已关闭")
+)
+
+type (
+	// QueueStats 客户端发送队列的统计
+	QueueStats struct {
+		Queued  int    `json:"queued"`  // 内存中待发送的消息数
+		Spilled int    `json:"spilled"` // 溢出到磁盘待发送的消息数
+		Sent    uint64 `json:"sent"`    // 已发送的消息数
+		Dropped uint64 `json:"dropped"` // 因队列满丢弃的消息数
+		Lag     int64  `json:"lag"`     // 最早的待发送消息已等待的时间(毫秒)
+	}
+
+	// clientQueue 单个客户端的有界发送队列,入队不会阻塞
+	clientQueue struct {
+		mu       sync.Mutex
+		size     int
+		overflow string
+		items    []queued
+		spill    *spillFile
+		notify   chan struct{}
+		space    chan struct{} // 等待空位时创建,出队或关闭时关闭以唤醒所有等待方
+		closed   bool
+		sent     uint64
+		dropped  uint64
+
+		spillDir   string
+		spillLimit int
+	}
+
+	queued struct {
+		payload []byte
+		time    int64
+		done    func(err error) // 写入连接后回调,丢弃或未写入时回调错误
+	}
+
+	// spillFile 溢出消息的磁盘文件,记录格式为 4字节长度 + 8字节入队时间 + 消息
+	spillFile struct {
+		file  *os.File
+		read  int64
+		write int64
+		count int
+		// 溢出消息的写入回调,按记录的序号保存
+		appended uint64
+		taken    uint64
+		done     map[uint64]func(err error)
+	}
+)
+
+func newClientQueue(size int, overflow, spillDir string, spillLimit int) *clientQueue {
+	return &clientQueue{
+		size:       size,
+		overflow:   overflow,
+		items:      make([]queued, 0, size),
+		notify:     make(chan struct{}, 1),
+		spillDir:   spillDir,
+		spillLimit: spillLimit,
+	}
+}
+
+// push 消息入队,done不为空时在消息写入连接后回调,返回错误时调用方需要断开连接,不回调done
+func (q *clientQueue) push(payload []byte, done func(err error)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errQueueClosed
+	}
+	item := queued{payload: payload, time: time.Now().UnixMilli(), done: done}
+	switch {
+	case q.spill != nil && q.spill.count > 0:
+		// 已有溢出的消息时后续消息也写入磁盘,保证顺序
+		if err := q.spillLocked(item); err != nil {
+			return err
+		}
+	case len(q.items) < q.size:
+		q.items = append(q.items, item)
+	case q.overflow == OverflowDropOldest:
+		q.items[0].finish(ErrQueueFull)
+		q.items = append(q.items[1:], item)
+		q.dropped++
+	case q.overflow == OverflowSpill:
+		if err := q.spillLocked(item); err != nil {
+			return err
+		}
+	default:
+		q.dropped++
+		return ErrQueueFull
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *clientQueue) spillLocked(item queued) error {
+	if q.spill == nil {
+		spill, err := newSpillFile(q.spillDir)
+		if err != nil {
+			return err
+		}
+		q.spill = spill
+	}
+	if q.spillLimit > 0 && q.spill.count >= q.spillLimit {
+		q.dropped++
+		return ErrQueueFull
+	}
+	return q.spill.append(item)
+}
+
+// pop 取出下一条消息,队列为空时等待,队列关闭或ctx结束时返回false,写入连接后需要调用 queued.finish
+func (q *clientQueue) pop(ctx context.Context) (queued, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return queued{}, false
+		}
+		if len(q.items) == 0 && q.spill != nil && q.spill.count > 0 {
+			// 内存队列已空,从磁盘读回一批
+			if err := q.refillLocked(); err != nil {
+				q.mu.Unlock()
+				return queued{}, false
+			}
+		}
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items[0] = queued{}
+			q.items = q.items[1:]
+			q.sent++
+			q.wakeLocked()
+			q.mu.Unlock()
+			return item, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return queued{}, false
+		}
+	}
+}
+
+// wait 等待队列有空位,队列关闭或ctx结束时返回false
+func (q *clientQueue) wait(ctx context.Context) bool {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return false
+		}
+		if len(q.items) < q.size && (q.spill == nil || q.spill.count == 0) {
+			q.mu.Unlock()
+			return true
+		}
+		if q.space == nil {
+			q.space = make(chan struct{})
+		}
+		space := q.space
+		q.mu.Unlock()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// wakeLocked 唤醒等待空位的一方
+func (q *clientQueue) wakeLocked() {
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+}
+
+func (q *clientQueue) refillLocked() error {
+	items := make([]queued, 0, q.size)
+	for len(items) < q.size && q.spill.count > 0 {
+		item, err := q.spill.next()
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	q.items = items
+	return nil
+}
+
+func (q *clientQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := QueueStats{Queued: len(q.items), Sent: q.sent, Dropped: q.dropped}
+	if q.spill != nil {
+		stats.Spilled = q.spill.count
+	}
+	if len(q.items) > 0 {
+		stats.Lag = time.Now().UnixMilli() - q.items[0].time
+	}
+	return stats
+}
+
+// reset 清空待发送的消息,队列可以继续使用
+func (q *clientQueue) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.discardLocked()
+	q.items = make([]queued, 0, q.size)
+	if q.spill != nil {
+		q.spill.remove()
+		q.spill = nil
+	}
+	q.wakeLocked()
+}
+
+// close 关闭队列并删除溢出文件
+func (q *clientQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.discardLocked()
+	q.items = nil
+	if q.spill != nil {
+		q.spill.remove()
+		q.spill = nil
+	}
+	close(q.notify)
+	q.wakeLocked()
+}
+
+// discardLocked 未发送的消息回调错误
+func (q *clientQueue) discardLocked() {
+	for _, item := range q.items {
+		item.finish(errQueueClosed)
+	}
+}
+
+// finish 回调消息的写入结果
+func (item queued) finish(err error) {
+	if item.done != nil {
+		item.done(err)
+	}
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("创建溢出目录失败: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "client-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("创建溢出文件失败: %w", err)
+	}
+	return &spillFile{file: file, done: make(map[uint64]func(err error))}, nil
+}
+
+func (s *spillFile) append(item queued) error {
+	record := make([]byte, 12+len(item.payload))
+	binary.BigEndian.PutUint32(record, uint32(len(item.payload)))
+	binary.BigEndian.PutUint64(record[4:], uint64(item.time))
+	copy(record[12:], item.payload)
+	if _, err := s.file.WriteAt(record, s.write); err != nil {
+		return fmt.Errorf("写入溢出文件失败: %w", err)
+	}
+	s.write += int64(len(record))
+	s.count++
+	if item.done != nil {
+		s.done[s.appended] = item.done
+	}
+	s.appended++
+	return nil
+}
+
+func (s *spillFile) next() (queued, error) {
+	header := make([]byte, 12)
+	if _, err := s.file.ReadAt(header, s.read); err != nil {
+		return queued{}, fmt.Errorf("读取溢出文件失败: %w", err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := s.file.ReadAt(payload, s.read+12); err != nil {
+		return queued{}, fmt.Errorf("读取溢出文件失败: %w", err)
+	}
+	s.read += int64(12 + len(payload))
+	s.count--
+	item := queued{payload: payload, time: int64(binary.BigEndian.Uint64(header[4:])), done: s.done[s.taken]}
+	delete(s.done, s.taken)
+	s.taken++
+	switch {
+	case s.count == 0:
+		// 全部读完后从头复用文件
+		s.read, s.write = 0, 0
+		_ = s.file.Truncate(0)
+	case s.read >= spillCompact && s.read >= s.write-s.read:
+		if err := s.compact(); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+// compact 将未读的记录移动到文件开头并截断
+func (s *spillFile) compact() error {
+	remain := s.write - s.read
+	buf := make([]byte, min(remain, 1<<20))
+	for moved := int64(0); moved < remain; {
+		chunk := buf[:min(int64(len(buf)), remain-moved)]
+		if _, err := s.file.ReadAt(chunk, s.read+moved); err != nil {
+			return fmt.Errorf("压缩溢出文件失败: %w", err)
+		}
+		if _, err := s.file.WriteAt(chunk, moved); err != nil {
+			return fmt.Errorf("压缩溢出文件失败: %w", err)
+		}
+		moved += int64(len(chunk))
+	}
+	if err := s.file.Truncate(remain); err != nil {
+		return fmt.Errorf("压缩溢出文件失败: %w", err)
+	}
+	s.read, s.write = 0, remain
+	return nil
+}
+
+// remove 删除文件,未读取的消息回调错误
+func (s *spillFile) remove() {
+	for _, done := range s.done {
+		done(errQueueClosed)
+	}
+	s.done = nil
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
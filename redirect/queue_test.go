@@ -0,0 +1,111 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSpillRefill 溢出到磁盘的消息读回后与内存中的消息保持入队顺序,写入回调按顺序对应
+func TestSpillRefill(t *testing.T) {
+	cases := []struct {
+		name  string
+		size  int
+		steps []int // 正数为入队的条数,负数为出队的条数
+		pad   int   // 消息的填充长度,用于触发溢出文件压缩
+	}{
+		{"no spill", 4, []int{3, -3}, 0},
+		{"spill", 2, []int{7, -7}, 0},
+		{"push while spilled", 3, []int{5, -2, 4, -1, 3, -9}, 0},
+		{"drain and spill again", 2, []int{5, -5, 6, -6}, 0},
+		{"refill partially read", 3, []int{10, -4, 2, -8}, 0},
+		{"compact", 4, []int{40, -30, 40, -50}, 256 << 10},
+	}
+	ctx := context.Background()
+	for _, c := range cases {
+		q := newClientQueue(c.size, OverflowSpill, t.TempDir(), 0)
+		pushed, popped := 0, 0
+		var acked []int
+		for _, step := range c.steps {
+			for ; step > 0; step-- {
+				n := pushed
+				payload := strconv.Itoa(n) + ":" + strings.Repeat("x", c.pad)
+				if err := q.push([]byte(payload), func(err error) {
+					if err != nil {
+						t.Errorf("%s: message %d done(%v)", c.name, n, err)
+					}
+					acked = append(acked, n)
+				}); err != nil {
+					t.Fatalf("%s: push() = %v", c.name, err)
+				}
+				pushed++
+			}
+			for ; step < 0; step++ {
+				item, ok := q.pop(ctx)
+				if !ok {
+					t.Fatalf("%s: pop() closed after %d messages", c.name, popped)
+				}
+				if n, _, _ := strings.Cut(string(item.payload), ":"); n != strconv.Itoa(popped) {
+					t.Errorf("%s: pop() = message %s, want %d", c.name, n, popped)
+				}
+				item.finish(nil)
+				popped++
+			}
+		}
+		if stats := q.stats(); stats.Queued != 0 || stats.Spilled != 0 {
+			t.Errorf("%s: stats() = %+v, want empty", c.name, stats)
+		}
+		if fmt.Sprint(acked) != fmt.Sprint(seqRange(popped)) {
+			t.Errorf("%s: acked %v", c.name, acked)
+		}
+		q.close()
+	}
+}
+
+func TestQueueWait(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name   string
+		wake   func(q *clientQueue)
+		expect bool
+	}{
+		{"pop", func(q *clientQueue) { q.pop(ctx) }, true},
+		{"reset", func(q *clientQueue) { q.reset() }, true},
+		{"close", func(q *clientQueue) { q.close() }, false},
+	}
+	for _, c := range cases {
+		q := newClientQueue(1, OverflowDisconnect, "", 0)
+		if err := q.push([]byte("1"), nil); err != nil {
+			t.Fatal(err)
+		}
+		result := make(chan bool)
+		go func() {
+			result <- q.wait(ctx)
+		}()
+		select {
+		case <-result:
+			t.Fatalf("%s: wait() returned on a full queue", c.name)
+		case <-time.After(50 * time.Millisecond):
+		}
+		c.wake(q)
+		select {
+		case ok := <-result:
+			if ok != c.expect {
+				t.Errorf("%s: wait() = %v, want %v", c.name, ok, c.expect)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: wait() was not woken", c.name)
+		}
+	}
+}
+
+func seqRange(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
@@ -1,10 +1,80 @@
 package redirect
 
+import (
+	"encoding/json"
+	"wechat-hub/auth"
+)
+
 type MessageRedirector interface {
 	SendMessage([]byte) error
 }
 
+// AckRedirector 消息入队后异步写入的转发器,消息写入连接或保存到积压后才回调ack,
+// 返回错误时不回调;ack可能在持有锁时调用,不能阻塞
+type AckRedirector interface {
+	MessageRedirector
+	SendMessageAck(bytes []byte, ack func(err error)) error
+}
+
+// TargetRedirector 有多个独立投递目标的转发器,投递箱为每个目标单独记录及重试,
+// 重试时不会重复投递到已成功的目标
+type TargetRedirector interface {
+	MessageRedirector
+	Targets() []string
+	SendTarget(target string, bytes []byte) error
+}
+
+// BestEffortRedirector 尽力投递的转发器,BestEffort返回true时不记录投递箱,发送失败不重试
+type BestEffortRedirector interface {
+	MessageRedirector
+	BestEffort() bool
+}
+
 type MessageReceiver interface {
 	OnMessage(OnMessage)
 }
-type OnMessage func(payload []byte, receiver string, id string) error
+
+// OnMessage 处理上报的消息,identity为消息来源的身份,返回的reply不为空时需回复给消息来源
+type OnMessage func(payload []byte, receiver string, identity *auth.Identity) (reply []byte, err error)
+
+// ClientRedirector 可以向指定客户端发送消息的转发器
+type ClientRedirector interface {
+	MessageRedirector
+	Clients() []ClientInfo
+	SendTo(client string, bytes []byte) error
+}
+
+// StateRedirector 可以保存最新状态的转发器,新连接的客户端可以立即获取
+type StateRedirector interface {
+	PublishState(name string, bytes []byte) error
+}
+
+// ClientInfo 客户端信息
+type ClientInfo struct {
+	ID          string `json:"id"`             // 客户端id,为客户端远程地址
+	Name        string `json:"name,omitempty"` // 客户端名称,连接时通过client参数指定
+	User        string `json:"user"`           // 认证用户
+	ConnectTime int64  `json:"connectTime"`    // 连接时间
+	Acked       int64  `json:"acked"`          // 客户端最后确认的序号
+
+	Subscriptions []Subscription `json:"subscriptions,omitempty"` // 连接的订阅
+	Queue         *QueueStats    `json:"queue,omitempty"`         // 发送队列统计
+}
+
+// messageHeader 消息中用于路由的字段
+type messageHeader struct {
+	Seq     int64  `json:"seq"` // 消息序号,未编号的消息为0
+	MsgID   string `json:"msgID"`
+	Time    int64  `json:"time"`
+	MsgType int    `json:"msgType"`
+	GID     string `json:"gid"` // 群id,私聊消息为空
+	UID     string `json:"uid"`
+	Event   string `json:"event"` // 系统消息事件
+}
+
+// parseHeader 解析消息的路由字段,解析失败时返回零值
+func parseHeader(payload []byte) messageHeader {
+	var header messageHeader
+	_ = json.Unmarshal(payload, &header)
+	return header
+}
@@ -0,0 +1,128 @@
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"wechat-hub/auth"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// Env 创建转发器时可以使用的公共依赖
+	Env struct {
+		Ctx       context.Context
+		Name      string // 转发器名称
+		DataDir   string // 数据目录
+		DB        *gorm.DB
+		Auth      *auth.Manager
+		OnMessage OnMessage // 转发器收到的命令
+	}
+
+	// Decoder 将转发器配置解析到结构体
+	Decoder func(v any) error
+
+	// Spec 已解析并校验的转发器配置
+	Spec struct {
+		Type    string
+		options any
+		create  func(env *Env) (MessageRedirector, error)
+	}
+
+	// Listener 需要监听端口的转发器配置,用于检查端口冲突
+	Listener interface {
+		Ports() []int
+	}
+
+	validator interface {
+		Validate() error
+	}
+
+	factory func(decode Decoder) (*Spec, error)
+)
+
+var factories = map[string]factory{}
+
+// Register 注册转发器类型,defaults返回带默认值的配置,配置实现 Validate() error 时在创建前校验
+func Register[T any](typ string, defaults func() *T, create func(env *Env, options *T) (MessageRedirector, error)) {
+	if _, ok := factories[typ]; ok {
+		panic(fmt.Sprintf("duplicate redirect type: %s", typ))
+	}
+	factories[typ] = func(decode Decoder) (*Spec, error) {
+		options := defaults()
+		if err := decode(options); err != nil {
+			return nil, err
+		}
+		if v, ok := any(options).(validator); ok {
+			if err := v.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		return &Spec{
+			Type:    typ,
+			options: options,
+			create: func(env *Env) (MessageRedirector, error) {
+				return create(env, options)
+			},
+		}, nil
+	}
+}
+
+// Types 已注册的转发器类型
+func Types() []string {
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Parse 按类型解析并校验转发器配置
+func Parse(typ string, decode Decoder) (*Spec, error) {
+	f, ok := factories[typ]
+	if !ok {
+		return nil, fmt.Errorf("未知的转发器类型 %q,可选 %s", typ, strings.Join(Types(), "、"))
+	}
+	return f(decode)
+}
+
+// Ports 转发器监听的端口
+func (s *Spec) Ports() []int {
+	if l, ok := s.options.(Listener); ok {
+		return l.Ports()
+	}
+	return nil
+}
+
+// New 创建转发器,需要监听端口的转发器会在后台开始服务
+func (s *Spec) New(env *Env) (MessageRedirector, error) {
+	return s.create(env)
+}
+
+func validPort(port int) bool {
+	return port > 0 && port < 65536
+}
+
+func checkHeartbeat(heartbeat time.Duration) error {
+	if heartbeat < 5*time.Second {
+		return fmt.Errorf("heartbeat: 不能小于5s")
+	}
+	return nil
+}
+
+func checkURL(s string, schemes ...string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("地址 %q 无效: %w", s, err)
+	}
+	if slices.Contains(schemes, u.Scheme) && u.Host != "" {
+		return nil
+	}
+	return fmt.Errorf("地址 %q 无效,需要以 %s:// 开头", s, strings.Join(schemes, "://、"))
+}
@@ -0,0 +1,224 @@
+package redirect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wechat-hub/auth"
+	"wechat-hub/hub"
+)
+
+type (
+	// SSERedirector 通过HTTP接口的 Server-Sent Events 推送消息,事件id为消息序号
+	// 队列满时断开连接,客户端携带 Last-Event-ID 重连后从积压中补发
+	SSERedirector struct {
+		fanout
+		heartbeat time.Duration
+		queueSize int
+	}
+
+	SSEOption func(s *SSERedirector)
+
+	// SSEConfig 事件流配置,接口为HTTP服务的 /msg/stream
+	SSEConfig struct {
+		Heartbeat time.Duration `yaml:"heartbeat"` // 心跳注释的发送间隔,防止代理断开空闲连接
+		Queue     int           `yaml:"queue"`     // 每个连接的发送队列长度,满时断开连接
+		Backlog   time.Duration `yaml:"backlog"`   // 积压消息的保留时间,用于 Last-Event-ID 补发,0为不保存
+	}
+)
+
+func (c *SSEConfig) Validate() error {
+	if c.Queue < 1 {
+		return fmt.Errorf("queue: 不能小于1")
+	}
+	if c.Backlog < 0 {
+		return fmt.Errorf("backlog: 不能小于0")
+	}
+	return checkHeartbeat(c.Heartbeat)
+}
+
+func init() {
+	Register("sse", func() *SSEConfig {
+		return &SSEConfig{Heartbeat: 15 * time.Second, Queue: 100, Backlog: 24 * time.Hour}
+	}, func(env *Env, c *SSEConfig) (MessageRedirector, error) {
+		backlog, err := newBacklog(env.Ctx, env.DB, env.Name, c.Backlog)
+		if err != nil {
+			return nil, err
+		}
+		return NewSSERedirector(env.Ctx, SSEHeartbeat(c.Heartbeat), SSEQueue(c.Queue), sseBacklog(backlog)), nil
+	})
+}
+
+// SSEHeartbeat 心跳间隔,最低5s
+func SSEHeartbeat(heartbeat time.Duration) SSEOption {
+	return func(s *SSERedirector) {
+		if heartbeat < time.Second*5 {
+			heartbeat = time.Second * 5
+		}
+		s.heartbeat = heartbeat
+	}
+}
+
+// SSEQueue 每个连接的发送队列长度
+func SSEQueue(size int) SSEOption {
+	return func(s *SSERedirector) {
+		if size < 1 {
+			size = 1
+		}
+		s.queueSize = size
+	}
+}
+
+func sseBacklog(backlog *backlog) SSEOption {
+	return func(s *SSERedirector) {
+		s.backlog = backlog
+	}
+}
+
+func NewSSERedirector(ctx context.Context, options ...SSEOption) *SSERedirector {
+	s := &SSERedirector{
+		fanout:    newFanout(ctx),
+		heartbeat: 15 * time.Second,
+		queueSize: 100,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// BestEffort 不保存积压时断线的浏览器无法补发,没有连接时消息直接丢弃,不需要投递箱重试
+func (s *SSERedirector) BestEffort() bool {
+	return !s.backlog.persistent()
+}
+
+// SendMessage 不保存积压时没有连接不视为失败
+func (s *SSERedirector) SendMessage(bytes []byte) error {
+	return s.SendMessageAck(bytes, nil)
+}
+
+func (s *SSERedirector) SendMessageAck(bytes []byte, ack func(err error)) error {
+	err := s.fanout.SendMessageAck(bytes, ack)
+	if errors.Is(err, ErrNoClient) {
+		if ack != nil {
+			ack(nil)
+		}
+		return nil
+	}
+	return err
+}
+
+var errStreamUnsupported = errors.New("不支持流式响应")
+
+// Stream 推送消息直到连接断开,filter为空时接收所有有权限的消息,lastID大于0时先补发之后的积压消息
+// 返回错误时还未写入响应
+func (s *SSERedirector) Stream(w http.ResponseWriter, r *http.Request, identity *auth.Identity, filter hub.SubscribeCommand, lastID int64) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errStreamUnsupported
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	user := r.RemoteAddr
+	if identity != nil {
+		user = identity.Username
+	}
+	client := &streamClient{
+		info: &ClientInfo{
+			ID:          r.RemoteAddr,
+			User:        user,
+			ConnectTime: time.Now().UnixMilli(),
+		},
+		identity: identity,
+		// 队列满时断开连接,客户端重连后补发
+		queue:  newClientQueue(s.queueSize, OverflowDisconnect, "", 0),
+		encode: sseEvent,
+		disconnect: func(error) {
+			cancel()
+		},
+	}
+	if filter.Validate() == nil {
+		client.subscriptions.add(filter)
+	}
+	resume := lastID > 0 && s.backlog.persistent()
+	var result hub.ResumeResult
+	if resume {
+		var err error
+		if result, err = s.backlog.resumeResult(lastID); err != nil {
+			return err
+		}
+		// 部分消息已过期清理时先发送gap事件,客户端可以通过接口查询缺失的消息
+		if result.Gap {
+			data, _ := json.Marshal(result)
+			_ = client.push([]byte("event: gap\ndata: " + string(data) + "\n\n"))
+		}
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 关闭nginx等代理的缓冲
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if resume {
+		client.position.start()
+	}
+	s.add(client)
+	slog.Info("SSE客户端连接", "client", client.info.ID, "user", user, "lastEventId", lastID)
+	defer func() {
+		s.remove(client)
+		slog.Info("SSE客户端断开", "client", client.info.ID, "user", user)
+	}()
+	if resume {
+		go s.resume(client, result.From)
+	}
+
+	for {
+		wait, done := context.WithTimeout(ctx, s.heartbeat)
+		item, ok := client.queue.pop(wait)
+		done()
+		if ctx.Err() != nil {
+			item.finish(ctx.Err())
+			return nil
+		}
+		if !ok {
+			// 超时没有消息,发送注释保持连接
+			item.payload = []byte(": ping\n\n")
+		}
+		_, err := w.Write(item.payload)
+		if err == nil {
+			flusher.Flush()
+		}
+		item.finish(err)
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// sseEvent 组装事件,seq为0时不设置事件id
+func sseEvent(seq int64, payload []byte) []byte {
+	var event bytes.Buffer
+	if seq > 0 {
+		event.WriteString("id: ")
+		event.WriteString(strconv.FormatInt(seq, 10))
+		event.WriteByte('\n')
+	}
+	event.WriteString("event: message\n")
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(line)
+		event.WriteByte('\n')
+	}
+	event.WriteByte('\n')
+	return event.Bytes()
+}
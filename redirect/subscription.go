@@ -0,0 +1,153 @@
+package redirect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"wechat-hub/auth"
+	"wechat-hub/hub"
+)
+
+type (
+	// Subscription 连接的订阅,没有订阅时接收所有消息
+	Subscription struct {
+		ID string `json:"id"`
+		hub.SubscribeCommand
+	}
+
+	subscriptions struct {
+		mu    sync.RWMutex
+		seq   int
+		items []Subscription
+	}
+)
+
+// Match 消息是否满足订阅条件
+func (s *Subscription) Match(header messageHeader) bool {
+	if len(s.Groups) > 0 && !slices.Contains(s.Groups, header.GID) {
+		return false
+	}
+	if len(s.Types) > 0 && !slices.Contains(s.Types, header.MsgType) {
+		return false
+	}
+	if len(s.Events) > 0 && !slices.Contains(s.Events, header.Event) {
+		return false
+	}
+	return true
+}
+
+func (s *subscriptions) add(command hub.SubscribeCommand) Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	sub := Subscription{ID: strconv.Itoa(s.seq), SubscribeCommand: command}
+	s.items = append(s.items, sub)
+	return sub
+}
+
+// remove 取消订阅,id为空时取消所有订阅
+func (s *subscriptions) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" {
+		s.items = nil
+		return true
+	}
+	n := len(s.items)
+	s.items = slices.DeleteFunc(s.items, func(sub Subscription) bool {
+		return sub.ID == id
+	})
+	return len(s.items) < n
+}
+
+func (s *subscriptions) list() []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.items)
+}
+
+// match 满足任一订阅即可,没有订阅时接收所有消息
+func (s *subscriptions) match(header messageHeader) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.items) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.items, func(sub Subscription) bool {
+		return sub.Match(header)
+	})
+}
+
+var (
+	errInvalidCommand      = errors.New("命令错误")
+	errUnknownSubscription = fmt.Errorf("%w: 订阅不存在", errInvalidCommand)
+	errGroupForbidden      = errors.New("没有群的授权")
+)
+
+// handle 处理订阅命令,不是订阅命令时ok为false
+func (s *subscriptions) handle(command hub.Command, identity *auth.Identity) (data any, ok bool, err error) {
+	switch command.Command {
+	case hub.CommandSubscribe:
+		var param hub.SubscribeCommand
+		if err = decodeCommand(command.Param, &param); err != nil {
+			return nil, true, err
+		}
+		for _, gid := range param.Groups {
+			if !identity.AllowGroup(gid) {
+				return nil, true, fmt.Errorf("%w: %s", errGroupForbidden, gid)
+			}
+		}
+		return s.add(param), true, nil
+	case hub.CommandUnsubscribe:
+		var param hub.UnsubscribeCommand
+		if err = decodeCommand(command.Param, &param); err == nil && !s.remove(param.ID) {
+			err = errUnknownSubscription
+		}
+		return nil, true, err
+	case hub.CommandSubscriptions:
+		return s.list(), true, nil
+	}
+	return nil, false, nil
+}
+
+// parseCommand 解析连接上收到的命令
+func parseCommand(message []byte) (hub.Command, bool) {
+	var command hub.Command
+	if err := json.Unmarshal(message, &command); err != nil {
+		return command, false
+	}
+	return command, true
+}
+
+// commandReply 连接自己处理的命令的执行结果,命令没有id时不回复
+func commandReply(command hub.Command, data any, err error) []byte {
+	if command.ID == "" {
+		return nil
+	}
+	result := hub.CommandResult{ID: command.ID, Command: command.Command, Msg: "OK", Data: data}
+	if errors.Is(err, errGroupForbidden) {
+		result.Code, result.Msg, result.Data = http.StatusForbidden, err.Error(), nil
+	} else if errors.Is(err, errInvalidCommand) {
+		result.Code, result.Msg, result.Data = http.StatusBadRequest, err.Error(), nil
+	} else if err != nil {
+		result.Code, result.Msg, result.Data = http.StatusInternalServerError, err.Error(), nil
+	}
+	reply, _ := json.Marshal(result)
+	return reply
+}
+
+func decodeCommand(raw json.RawMessage, param hub.CommandParam) error {
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, param); err != nil {
+			return fmt.Errorf("%w: 参数解析失败 %w", errInvalidCommand, err)
+		}
+	}
+	if err := param.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidCommand, err)
+	}
+	return nil
+}
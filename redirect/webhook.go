@@ -0,0 +1,162 @@
+package redirect
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	WebhookTimestampHeader = "X-Hub-Timestamp"
+	WebhookSignatureHeader = "X-Hub-Signature"
+)
+
+type WebhookRedirector struct {
+	urls   []string
+	secret []byte
+	client *resty.Client
+}
+
+type WebhookOption func(h *WebhookRedirector)
+
+// WebhookConfig webhook配置
+type WebhookConfig struct {
+	URLs      []string      `yaml:"urls"`
+	Secret    string        `yaml:"secret"`
+	Timeout   time.Duration `yaml:"timeout"`
+	Retry     int           `yaml:"retry"`
+	RetryWait time.Duration `yaml:"retryWait"`
+}
+
+func (c *WebhookConfig) Validate() error {
+	if len(c.URLs) == 0 {
+		return errors.New("urls: 不能为空")
+	}
+	for i, u := range c.URLs {
+		if err := checkURL(u, "http", "https"); err != nil {
+			return fmt.Errorf("urls[%d]: %w", i, err)
+		}
+	}
+	if c.Timeout <= 0 {
+		return errors.New("timeout: 必须大于0")
+	}
+	if c.Retry < 0 {
+		return errors.New("retry: 不能小于0")
+	}
+	return nil
+}
+
+func init() {
+	Register("webhook", func() *WebhookConfig {
+		return &WebhookConfig{Timeout: 10 * time.Second, Retry: 3, RetryWait: time.Second}
+	}, func(_ *Env, c *WebhookConfig) (MessageRedirector, error) {
+		return NewWebhookMessageHandler(c.URLs,
+			WebhookSecret(c.Secret),
+			WebhookTimeout(c.Timeout),
+			WebhookRetry(c.Retry, c.RetryWait),
+		), nil
+	})
+}
+
+// WebhookSecret 设置签名密钥,为空时不签名
+func WebhookSecret(secret string) WebhookOption {
+	return func(h *WebhookRedirector) {
+		h.secret = []byte(secret)
+	}
+}
+
+// WebhookRetry 非2xx响应或请求出错时的重试次数及间隔
+func WebhookRetry(count int, wait time.Duration) WebhookOption {
+	return func(h *WebhookRedirector) {
+		h.client.SetRetryCount(count).SetRetryWaitTime(wait).SetRetryMaxWaitTime(wait * 10)
+	}
+}
+
+// WebhookTimeout 单次请求超时时间
+func WebhookTimeout(timeout time.Duration) WebhookOption {
+	return func(h *WebhookRedirector) {
+		h.client.SetTimeout(timeout)
+	}
+}
+
+func NewWebhookMessageHandler(urls []string, options ...WebhookOption) *WebhookRedirector {
+	h := &WebhookRedirector{
+		urls: urls,
+		client: resty.New().
+			SetTimeout(10 * time.Second).
+			SetRetryCount(3).
+			SetRetryWaitTime(time.Second).
+			SetRetryMaxWaitTime(10 * time.Second).
+			AddRetryCondition(func(resp *resty.Response, err error) bool {
+				return err != nil || resp.IsError()
+			}),
+	}
+	for _, option := range options {
+		option(h)
+	}
+	return h
+}
+
+// Sign 计算签名 hex(hmac-sha256(secret, timestamp + "." + body))
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Targets 每个地址单独投递及重试
+func (h *WebhookRedirector) Targets() []string {
+	return h.urls
+}
+
+// SendTarget 推送到指定地址,地址已不在配置中时返回错误
+func (h *WebhookRedirector) SendTarget(url string, bytes []byte) error {
+	if !slices.Contains(h.urls, url) {
+		return fmt.Errorf("webhook地址 %s 不存在", url)
+	}
+	if err := h.post(url, bytes); err != nil {
+		slog.Error("webhook推送失败", "url", url, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (h *WebhookRedirector) SendMessage(bytes []byte) error {
+	var errs []error
+	for _, url := range h.urls {
+		if err := h.post(url, bytes); err != nil {
+			slog.Error("webhook推送失败", "url", url, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *WebhookRedirector) post(url string, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := h.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(WebhookTimestampHeader, timestamp).
+		SetBody(body)
+	if len(h.secret) > 0 {
+		req.SetHeader(WebhookSignatureHeader, Sign(h.secret, timestamp, body))
+	}
+	resp, err := req.Post(url)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("webhook %s: %s", url, resp.Status())
+	}
+	return nil
+}
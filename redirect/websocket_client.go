@@ -2,21 +2,124 @@ package redirect
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
+	"wechat-hub/auth"
+	"wechat-hub/hub"
 )
 
 type WSClientRedirector struct {
 	serverUrl string
-	messages  chan []byte
 	heartbeat time.Duration
-	server    wsConnection
+	connected atomic.Bool
 	onMessage OnMessage
+	header    http.Header
+	identity  *auth.Identity
+
+	// 消息编号及积压,服务端确认过序号后断线期间的消息在重连后补发
+	backlog   *backlog
+	sendMu    sync.Mutex
+	resumable atomic.Bool
+	// 待发送的消息,入队不阻塞,断线时未发送的消息留到重连后发送
+	queue    *clientQueue
+	position position
+	resuming sync.WaitGroup
 }
 
 type WSClientOption func(h *WSClientRedirector)
 
+// WSClientConfig websocket客户端配置
+type WSClientConfig struct {
+	URL       string            `yaml:"url"`
+	Heartbeat time.Duration     `yaml:"heartbeat"`
+	Queue     int               `yaml:"queue"`   // 待发送消息的队列长度,满时返回错误由投递箱稍后重试
+	Headers   map[string]string `yaml:"headers"` // 连接时携带的请求头,如 Authorization
+	Scopes    []string          `yaml:"scopes"`  // 服务端下发命令的权限,为空时不限制
+	Groups    []string          `yaml:"groups"`  // 服务端下发命令可以操作的群,为空时不限制
+	Backlog   time.Duration     `yaml:"backlog"` // 积压消息的保留时间,服务端确认过序号后重连时补发,0为不保存
+}
+
+func (c *WSClientConfig) Validate() error {
+	if err := checkURL(c.URL, "ws", "wss"); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	for _, scope := range c.Scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			return fmt.Errorf("scopes: 未知的权限范围 %q", scope)
+		}
+	}
+	if c.Queue < 1 {
+		return fmt.Errorf("queue: 不能小于1")
+	}
+	if c.Backlog < 0 {
+		return fmt.Errorf("backlog: 不能小于0")
+	}
+	return checkHeartbeat(c.Heartbeat)
+}
+
+func init() {
+	Register("websocketClient", func() *WSClientConfig {
+		return &WSClientConfig{Heartbeat: 10 * time.Second, Queue: 100, Backlog: 24 * time.Hour}
+	}, func(env *Env, c *WSClientConfig) (MessageRedirector, error) {
+		header := http.Header{}
+		for k, v := range c.Headers {
+			header.Set(k, v)
+		}
+		identity := &auth.Identity{Username: env.Name}
+		if len(c.Scopes) > 0 {
+			identity.Scopes = c.Scopes
+		}
+		if len(c.Groups) > 0 {
+			identity.Groups = c.Groups
+		}
+		backlog, err := newBacklog(env.Ctx, env.DB, env.Name, c.Backlog)
+		if err != nil {
+			return nil, err
+		}
+		client := NewWebsocketClientMessageHandler(env.Ctx, c.URL,
+			WSClientHeartbeat(c.Heartbeat), WSClientQueue(c.Queue), WSClientHeader(header), WSClientIdentity(identity), wsClientBacklog(backlog))
+		client.OnMessage(env.OnMessage)
+		return client, nil
+	})
+}
+
+// WSClientHeader 连接时携带的请求头
+func WSClientHeader(header http.Header) WSClientOption {
+	return func(h *WSClientRedirector) {
+		h.header = header
+	}
+}
+
+// WSClientIdentity 服务端下发命令时使用的身份,默认不限制权限
+func WSClientIdentity(identity *auth.Identity) WSClientOption {
+	return func(h *WSClientRedirector) {
+		h.identity = identity
+	}
+}
+
+func wsClientBacklog(backlog *backlog) WSClientOption {
+	return func(h *WSClientRedirector) {
+		h.backlog = backlog
+	}
+}
+
+// WSClientQueue 待发送消息的队列长度
+func WSClientQueue(size int) WSClientOption {
+	return func(h *WSClientRedirector) {
+		if size < 1 {
+			size = 1
+		}
+		h.queue = newClientQueue(size, OverflowDisconnect, "", 0)
+	}
+}
+
 func WSClientHeartbeat(heartbeat time.Duration) WSClientOption {
 	return func(h *WSClientRedirector) {
 		// 最低5s心跳
@@ -29,33 +132,57 @@ func WSClientHeartbeat(heartbeat time.Duration) WSClientOption {
 func NewWebsocketClientMessageHandler(ctx context.Context, serverUrl string, options ...WSClientOption) *WSClientRedirector {
 	h := &WSClientRedirector{
 		serverUrl: serverUrl,
-		messages:  make(chan []byte, 10),
+		backlog:   &backlog{},
+		queue:     newClientQueue(100, OverflowDisconnect, "", 0),
 	}
 	for _, option := range options {
 		option(h)
 	}
+	if h.identity == nil {
+		// 主动连接的服务端视为可信来源,不限制权限
+		h.identity = &auth.Identity{Username: serverUrl}
+	}
 	go h.serve(ctx)
 	return h
 }
 
 func (h *WSClientRedirector) serve(ctx context.Context) {
+	if _, ok, err := h.backlog.acked(h.serverUrl); err != nil {
+		slog.Error("读取确认序号失败", "server", h.serverUrl, "err", err)
+	} else {
+		h.resumable.Store(ok)
+	}
 	for {
-		conn, _, err := websocket.DefaultDialer.Dial(h.serverUrl, nil)
+		conn, _, err := websocket.DefaultDialer.Dial(h.serverUrl, h.header)
 		if err != nil {
 			slog.Error("websocket连接失败 等待重试", "server", h.serverUrl, "error", err)
 			time.Sleep(time.Second * 5)
 			continue
 		}
 		slog.Info("websocket连接成功", "server", h.serverUrl)
+		connCtx, cancel := context.WithCancel(ctx)
 		// 创建client
-		c := newClient(conn, h.heartbeat, func(messageType int, message []byte) {
-			if h.onMessage != nil {
-				_ = h.onMessage(message, "WS_CLIENT", h.serverUrl)
+		var c wsConnection
+		c = newClient(conn, h.heartbeat, func(messageType int, message []byte) {
+			// 续传命令由连接自己处理,其余命令交给hub
+			reply, ok := h.handle(connCtx, c, message)
+			if !ok && h.onMessage != nil {
+				reply, _ = h.onMessage(message, "WS_CLIENT", h.identity)
+			}
+			if reply != nil {
+				if err := c.SendMessage(reply); err != nil {
+					slog.Error("回复消息失败", "server", h.serverUrl, "err", err)
+				}
 			}
 		})
-		h.server = c
-		go h.sendMessage()
-		if err := c.Serve(ctx); err == nil {
+		h.connect(connCtx)
+		go h.pump(connCtx, c)
+		err = c.Serve(ctx)
+		h.connected.Store(false)
+		// 等待补发结束,下次连接重新补发
+		cancel()
+		h.resuming.Wait()
+		if err == nil {
 			return
 		}
 		slog.Error("websocket连接断开 等待重连", "server", h.serverUrl, "error", err)
@@ -63,18 +190,146 @@ func (h *WSClientRedirector) serve(ctx context.Context) {
 	}
 }
 
-func (h *WSClientRedirector) sendMessage() {
-	for message := range h.messages {
-		if err := h.server.SendMessage(message); err != nil {
-			slog.Error("发送消息失败", "err", err)
+// connect 连接成功后开始发送,服务端确认过序号时从确认的序号之后补发,队列中未发送的消息也在补发范围内
+func (h *WSClientRedirector) connect(ctx context.Context) {
+	seq, ok, err := h.backlog.acked(h.serverUrl)
+	if err != nil {
+		slog.Error("读取确认序号失败", "server", h.serverUrl, "err", err)
+	}
+	h.sendMu.Lock()
+	defer h.sendMu.Unlock()
+	if ok {
+		h.queue.reset()
+		h.position.start()
+		h.resume(ctx, seq)
+	}
+	h.connected.Store(true)
+}
+
+// pump 将队列中的消息写入连接,连接断开时剩余的消息留在队列中
+func (h *WSClientRedirector) pump(ctx context.Context, c wsConnection) {
+	for {
+		item, ok := h.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		if err := c.SendMessageAck(item.payload, item.done); err != nil {
+			item.finish(err)
+			slog.Error("发送消息失败", "server", h.serverUrl, "err", err)
 			return
 		}
 	}
 }
 
+// handle 处理服务端的确认及续传命令,其余命令返回false交给hub
+func (h *WSClientRedirector) handle(ctx context.Context, c wsConnection, message []byte) (reply []byte, ok bool) {
+	command, ok := parseCommand(message)
+	if !ok {
+		return nil, false
+	}
+	var err error
+	switch command.Command {
+	case hub.CommandAck:
+		var param hub.AckCommand
+		if err = decodeCommand(command.Param, &param); err == nil {
+			h.position.acked.Store(param.Seq)
+			if h.backlog.persistent() {
+				if err = h.backlog.ack(h.serverUrl, param.Seq); err == nil {
+					h.resumable.Store(true)
+				}
+			}
+		}
+	case hub.CommandResume:
+		var param hub.ResumeCommand
+		if err = decodeCommand(command.Param, &param); err != nil {
+			break
+		}
+		if !h.backlog.persistent() {
+			err = fmt.Errorf("%w: 未保存积压消息,无法补发", errInvalidCommand)
+			break
+		}
+		seq := param.Seq
+		if seq == 0 {
+			var acked bool
+			if seq, acked, err = h.backlog.acked(h.serverUrl); err != nil {
+				break
+			} else if !acked {
+				seq = h.backlog.last()
+			}
+		}
+		var result hub.ResumeResult
+		if result, err = h.backlog.resumeResult(seq); err != nil {
+			break
+		}
+		// 先回复再补发,保证结果在补发的消息之前
+		h.position.start()
+		if reply = commandReply(command, result, nil); reply != nil {
+			_ = c.SendMessage(reply)
+		}
+		h.resume(ctx, result.From)
+		return nil, true
+	default:
+		return nil, false
+	}
+	return commandReply(command, nil, err), true
+}
+
+// resume 在后台从seq之后补发积压消息,连接断开时结束
+func (h *WSClientRedirector) resume(ctx context.Context, seq int64) {
+	h.resuming.Add(1)
+	go func() {
+		defer h.resuming.Done()
+		err := h.position.resume(h.backlog, seq, func(f frame) error {
+			// 等待队列有空位,补发不占用实时消息的队列空间
+			if !h.queue.wait(ctx) {
+				return errQueueClosed
+			}
+			return h.queue.push(f.payload, nil)
+		})
+		if err != nil && ctx.Err() == nil {
+			slog.Error("补发积压消息失败", "server", h.serverUrl, "err", err)
+		}
+	}()
+}
+
+var ErrDisconnected = errors.New("websocket未连接")
+
 func (h *WSClientRedirector) SendMessage(bytes []byte) error {
-	h.messages <- bytes
-	return nil
+	return h.SendMessageAck(bytes, nil)
+}
+
+// SendMessageAck 服务端确认过序号时写入积压后确认,重连后可以补发,否则写入连接后确认
+func (h *WSClientRedirector) SendMessageAck(bytes []byte, ack func(err error)) error {
+	// 未连接且无法补发时返回错误,由投递箱稍后重试
+	resumable := h.backlog.persistent() && h.resumable.Load()
+	if !h.connected.Load() && !resumable {
+		return ErrDisconnected
+	}
+	h.sendMu.Lock()
+	defer h.sendMu.Unlock()
+	f, err := h.backlog.append(bytes)
+	if err != nil {
+		return err
+	}
+	if ack != nil && resumable {
+		ack(nil)
+		ack = nil
+	}
+	// 未连接时消息已保存,重连后补发
+	if !h.connected.Load() && resumable {
+		return nil
+	}
+	// 入队不阻塞,队列满时返回错误由投递箱稍后重试
+	sent := false
+	err = h.position.deliver(f, func(f frame) error {
+		sent = true
+		return h.queue.push(f.payload, ack)
+	})
+	if err == nil && !sent && ack != nil {
+		// 补发中,消息已保存,由补发发送
+		ack(nil)
+	}
+	return err
 }
 
 func (h *WSClientRedirector) OnMessage(fn OnMessage) {
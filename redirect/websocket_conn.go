@@ -2,8 +2,10 @@ package redirect
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -13,14 +15,24 @@ type wsConnection interface {
 	Serve(ctx context.Context) error
 	Close()
 	SendMessage(message []byte) error
+	// SendMessageAck 写入连接后回调done,返回错误时不回调
+	SendMessageAck(message []byte, done func(err error)) error
+}
+
+// outgoing 待写入连接的消息
+type outgoing struct {
+	message []byte
+	done    func(err error)
 }
 
 type connection struct {
 	*websocket.Conn
 	heartbeat         time.Duration
-	messageBufferPool chan []byte
-	exit              chan error
+	messageBufferPool chan outgoing
+	exit              chan error // 读写两个goroutine各报告一次,Serve返回后不再接收,带缓冲避免阻塞
+	mu                sync.Mutex // 保护cancelFn及closed,Close可能在Serve开始前由其他goroutine调用
 	cancelFn          context.CancelFunc
+	closed            bool
 	receiveMessage    func(messageType int, message []byte)
 }
 
@@ -29,18 +41,25 @@ func newClient(conn *websocket.Conn, heartbeat time.Duration, receiveMessage fun
 	return &connection{
 		Conn:              conn,
 		heartbeat:         heartbeat,
-		messageBufferPool: make(chan []byte, 5),
-		exit:              make(chan error),
+		messageBufferPool: make(chan outgoing, 5),
+		exit:              make(chan error, 2),
 		receiveMessage:    receiveMessage,
 	}
 }
 
 func (c *connection) Serve(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
 	c.cancelFn = cancel
+	if c.closed {
+		cancel()
+	}
+	c.mu.Unlock()
 	var ticker *time.Ticker
+	var tick <-chan time.Time
 	if c.heartbeat > 0 {
 		ticker = time.NewTicker(c.heartbeat)
+		tick = ticker.C
 	}
 	defer func() {
 		cancel()
@@ -49,6 +68,10 @@ func (c *connection) Serve(ctx context.Context) error {
 		}
 		close(c.messageBufferPool)
 		_ = c.Conn.Close()
+		// 未写入的消息回调错误
+		for m := range c.messageBufferPool {
+			m.finish(errConnectionClosed)
+		}
 	}()
 	go c.readMessage()
 	go c.sendMessage()
@@ -56,7 +79,7 @@ func (c *connection) Serve(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-ticker.C:
+		case <-tick:
 			if err := c.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(2*time.Second)); err != nil {
 				slog.Error("心跳消息出错", "error", err)
 				return err
@@ -67,22 +90,38 @@ func (c *connection) Serve(ctx context.Context) error {
 	}
 }
 func (c *connection) Close() {
-	if c.cancelFn != nil {
-		c.cancelFn()
+	c.mu.Lock()
+	c.closed = true
+	cancel := c.cancelFn
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
 	} else {
 		_ = c.Conn.Close()
 	}
 }
-func (c *connection) SendMessage(message []byte) (err error) {
+func (c *connection) SendMessage(message []byte) error {
+	return c.SendMessageAck(message, nil)
+}
+
+func (c *connection) SendMessageAck(message []byte, done func(err error)) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
-	c.messageBufferPool <- message
+	c.messageBufferPool <- outgoing{message: message, done: done}
 	return nil
 }
 
+var errConnectionClosed = errors.New("连接已关闭")
+
+func (m outgoing) finish(err error) {
+	if m.done != nil {
+		m.done(err)
+	}
+}
+
 func (c *connection) readMessage() {
 	for {
 		messageType, message, err := c.ReadMessage()
@@ -123,8 +162,12 @@ func (c *connection) onReceiveMessage(messageType int, message []byte) {
 // sendMessage 将缓冲队列中的信息转发到服务器
 func (c *connection) sendMessage() {
 	for {
-		message := <-c.messageBufferPool
-		err := c.WriteMessage(websocket.TextMessage, message)
+		m, ok := <-c.messageBufferPool
+		if !ok {
+			return
+		}
+		err := c.WriteMessage(websocket.TextMessage, m.message)
+		m.finish(err)
 		if err != nil {
 			slog.Error("发送消息出错", "error", err)
 			c.exit <- err
@@ -2,28 +2,110 @@ package redirect
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
 	"time"
 	"wechat-hub/auth"
+	"wechat-hub/hub"
 
 	"github.com/eatmoreapple/openwechat"
 	"github.com/gorilla/websocket"
 )
 
-type WSServerRedirector struct {
-	upgrader   websocket.Upgrader
-	messages   chan []byte
-	heartbeat  time.Duration
-	register   chan wsConnection
-	unregister chan wsConnection
-	clients    map[wsConnection]struct{}
-	onMessage  OnMessage
-	auth       *auth.Manager
-}
+type (
+	WSServerRedirector struct {
+		fanout
+		upgrader  websocket.Upgrader
+		heartbeat time.Duration
+		onMessage OnMessage
+		auth      *auth.Manager
+
+		// 每个客户端的发送队列
+		queueSize  int
+		overflow   string
+		spillDir   string
+		spillLimit int
+	}
+
+	wsClient struct {
+		wsConnection
+		*streamClient
+		name string // 客户端名称,用于保存确认的序号
+	}
+)
 type WSServerOption func(h *WSServerRedirector)
 
+// WSServerConfig websocket服务端配置
+type WSServerConfig struct {
+	Port      int           `yaml:"port"`
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	Auth      bool          `yaml:"auth"` // 是否需要认证,不认证时客户端拥有所有权限
+
+	Queue      int    `yaml:"queue"`      // 每个客户端的发送队列长度
+	Overflow   string `yaml:"overflow"`   // 队列满时的处理方式 dropOldest、disconnect、spill
+	SpillDir   string `yaml:"spillDir"`   // 溢出消息的保存目录,默认为 {data}/{转发器名称小写}/spill
+	SpillLimit int    `yaml:"spillLimit"` // 每个客户端最多溢出的消息数,超过后断开连接,0为不限制
+
+	Backlog time.Duration `yaml:"backlog"` // 积压消息的保留时间,断线重连的客户端可以补发,0为不保存
+}
+
+func (c *WSServerConfig) Validate() error {
+	if !validPort(c.Port) {
+		return fmt.Errorf("port: 端口 %d 无效", c.Port)
+	}
+	if c.Queue < 1 {
+		return fmt.Errorf("queue: 不能小于1")
+	}
+	if !slices.Contains([]string{OverflowDropOldest, OverflowDisconnect, OverflowSpill}, c.Overflow) {
+		return fmt.Errorf("overflow: 未知的处理方式 %q,可选 %s、%s、%s", c.Overflow, OverflowDropOldest, OverflowDisconnect, OverflowSpill)
+	}
+	if c.SpillLimit < 0 {
+		return fmt.Errorf("spillLimit: 不能小于0")
+	}
+	if c.Backlog < 0 {
+		return fmt.Errorf("backlog: 不能小于0")
+	}
+	return checkHeartbeat(c.Heartbeat)
+}
+
+func (c *WSServerConfig) Ports() []int {
+	return []int{c.Port}
+}
+
+func init() {
+	Register("websocketServer", func() *WSServerConfig {
+		return &WSServerConfig{Port: 18080, Heartbeat: 10 * time.Second, Auth: true, Queue: 100, Overflow: OverflowDropOldest, Backlog: 24 * time.Hour}
+	}, func(env *Env, c *WSServerConfig) (MessageRedirector, error) {
+		spillDir := c.SpillDir
+		if spillDir == "" {
+			spillDir = path.Join(env.DataDir, strings.ToLower(env.Name), "spill")
+		}
+		backlog, err := newBacklog(env.Ctx, env.DB, env.Name, c.Backlog)
+		if err != nil {
+			return nil, err
+		}
+		options := []WSServerOption{
+			WSServerHeartbeat(c.Heartbeat),
+			WSServerQueue(c.Queue, c.Overflow),
+			WSServerSpill(spillDir, c.SpillLimit),
+			wsServerBacklog(backlog),
+		}
+		if c.Auth {
+			options = append(options, WSServerAuth(env.Auth))
+		}
+		server := NewWebsocketServerMessageHandler(env.Ctx, options...)
+		server.OnMessage(env.OnMessage)
+		go server.ListenAndServe(c.Port)
+		return server, nil
+	})
+}
+
 func WSServerHeartbeat(heartbeat time.Duration) WSServerOption {
 	return func(h *WSServerRedirector) {
 		// 最低5s心跳
@@ -34,6 +116,31 @@ func WSServerHeartbeat(heartbeat time.Duration) WSServerOption {
 	}
 }
 
+// WSServerQueue 每个客户端的发送队列长度及队列满时的处理方式
+func WSServerQueue(size int, overflow string) WSServerOption {
+	return func(h *WSServerRedirector) {
+		if size < 1 {
+			size = 1
+		}
+		h.queueSize = size
+		h.overflow = overflow
+	}
+}
+
+// WSServerSpill 溢出到磁盘时的保存目录及每个客户端最多溢出的消息数
+func WSServerSpill(dir string, limit int) WSServerOption {
+	return func(h *WSServerRedirector) {
+		h.spillDir = dir
+		h.spillLimit = limit
+	}
+}
+
+func wsServerBacklog(backlog *backlog) WSServerOption {
+	return func(h *WSServerRedirector) {
+		h.backlog = backlog
+	}
+}
+
 func WSServerAuth(manager *auth.Manager) WSServerOption {
 	return func(h *WSServerRedirector) {
 		h.auth = manager
@@ -42,7 +149,7 @@ func WSServerAuth(manager *auth.Manager) WSServerOption {
 
 func NewWebsocketServerMessageHandler(ctx context.Context, options ...WSServerOption) *WSServerRedirector {
 	h := &WSServerRedirector{
-		messages: make(chan []byte, 10), // 消息缓冲区
+		fanout: newFanout(ctx),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -50,81 +157,137 @@ func NewWebsocketServerMessageHandler(ctx context.Context, options ...WSServerOp
 				return true
 			},
 		},
-		register:   make(chan wsConnection),
-		unregister: make(chan wsConnection),
-		clients:    make(map[wsConnection]struct{}),
+		queueSize: 100,
+		overflow:  OverflowDropOldest,
+		spillDir:  path.Join(os.TempDir(), "wechat-hub-spill"),
 	}
 	for _, option := range options {
 		option(h)
 	}
-	go h.serve(ctx)
 	return h
 }
-func (h *WSServerRedirector) serve(ctx context.Context) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer func() {
-		cancel()
-	}()
-	go h.sendMessage()
-	for {
-		select {
-		case c := <-h.register:
-			h.clients[c] = struct{}{}
-			go func() {
-				_ = c.Serve(ctx)
-			}()
-		case c := <-h.unregister:
-			delete(h.clients, c)
-			c.Close()
-		case <-ctx.Done():
-			close(h.register)
-			close(h.unregister)
-			close(h.messages)
-			clear(h.clients)
-			return
-		}
+
+// cursorKey 保存确认序号使用的客户端标识,同名客户端按认证用户区分
+func (c *wsClient) cursorKey() string {
+	if c.identity != nil {
+		return c.identity.Username + "/" + c.name
 	}
+	return c.name
 }
 
-func (h *WSServerRedirector) sendMessage() {
-	for message := range h.messages {
-		for c := range h.clients {
-			if err := c.SendMessage(message); err != nil {
-				slog.Error("发送消息失败", "err", err)
-				h.unregister <- c
+// handle 处理连接自己的订阅及续传命令,其余命令返回false交给hub
+func (h *WSServerRedirector) handle(c *wsClient, message []byte) (reply []byte, ok bool) {
+	command, ok := parseCommand(message)
+	if !ok {
+		return nil, false
+	}
+	data, ok, err := c.subscriptions.handle(command, c.identity)
+	if ok {
+		return commandReply(command, data, err), true
+	}
+	switch command.Command {
+	case hub.CommandAck:
+		var param hub.AckCommand
+		if err = decodeCommand(command.Param, &param); err == nil {
+			c.position.acked.Store(param.Seq)
+			if c.name != "" && h.backlog.persistent() {
+				err = h.backlog.ack(c.cursorKey(), param.Seq)
 			}
 		}
+	case hub.CommandResume:
+		var seq int64
+		if seq, err = h.resumeFrom(c, command.Param); err != nil {
+			break
+		}
+		var result hub.ResumeResult
+		if result, err = h.backlog.resumeResult(seq); err != nil {
+			break
+		}
+		// 先回复再补发,保证结果在补发的消息之前
+		c.position.start()
+		if reply = commandReply(command, result, nil); reply != nil {
+			_ = c.push(reply)
+		}
+		go h.resume(c.streamClient, result.From)
+		return nil, true
+	default:
+		return nil, false
+	}
+	return commandReply(command, data, err), true
+}
+
+// resumeFrom 补发的起始序号,没有指定时使用客户端最后确认的序号
+func (h *WSServerRedirector) resumeFrom(c *wsClient, raw json.RawMessage) (int64, error) {
+	var param hub.ResumeCommand
+	if err := decodeCommand(raw, &param); err != nil {
+		return 0, err
+	}
+	if !h.backlog.persistent() {
+		return 0, fmt.Errorf("%w: 未保存积压消息,无法补发", errInvalidCommand)
+	}
+	if param.Seq > 0 {
+		return param.Seq, nil
+	}
+	if c.name == "" {
+		return 0, fmt.Errorf("%w: 连接未指定client参数,需要提供seq", errInvalidCommand)
+	}
+	seq, ok, err := h.backlog.acked(c.cursorKey())
+	if err != nil || ok {
+		return seq, err
+	}
+	// 没有确认过的客户端从当前位置开始
+	return h.backlog.last(), nil
+}
+
+// pump 将发送队列中的消息写入连接
+func (c *wsClient) pump(ctx context.Context) {
+	for {
+		item, ok := c.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		if err := c.wsConnection.SendMessageAck(item.payload, item.done); err != nil {
+			item.finish(err)
+			c.Close()
+			return
+		}
 	}
 }
 
 func (h *WSServerRedirector) Register(dispatcher *openwechat.MessageMatchDispatcher) {
 	dispatcher.OnText(func(ctx *openwechat.MessageContext) {
-		h.messages <- []byte(ctx.Message.Content)
+		_ = h.SendMessage([]byte(ctx.Message.Content))
 	})
 }
 
+// authenticate 认证连接,支持 Bearer 令牌、basic-auth 及 query 参数
+func (h *WSServerRedirector) authenticate(r *http.Request) (*auth.Identity, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return h.auth.CheckToken(token)
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		return h.auth.Authenticate(username, password)
+	}
+	query := r.URL.Query()
+	if token := query.Get("token"); token != "" {
+		return h.auth.CheckToken(token)
+	}
+	// query 中传递密码容易被记录到访问日志,仅为兼容保留
+	slog.Warn("WebsocketServerMessageHandler Auth 使用query传递密码,建议改用令牌", "remote", r.RemoteAddr)
+	return h.auth.Authenticate(query.Get("username"), query.Get("password"))
+}
+
 func (h *WSServerRedirector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	currentUser := r.RemoteAddr
+	var identity *auth.Identity
 	if h.auth != nil {
-		// header basic-auth认证
-		username, password, ok := r.BasicAuth()
-		if ok {
-			if !h.auth.CheckUser(username, password) {
-				slog.Error("WebsocketServerMessageHandler Auth 用户名或密码错误", "username", username, "password", password)
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-				return
-			}
-		} else {
-			// query 认证
-			username = r.URL.Query().Get("username")
-			password = r.URL.Query().Get("password")
-			if !h.auth.CheckUser(username, password) {
-				slog.Error("WebsocketServerMessageHandler Auth 用户名或密码错误", "username", username, "password", password)
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-				return
-			}
+		var err error
+		if identity, err = h.authenticate(r); err != nil {
+			slog.Error("WebsocketServerMessageHandler Auth 认证失败", "remote", r.RemoteAddr, "err", err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
 		}
-		currentUser = username
+		currentUser = identity.Username
 	}
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -132,16 +295,44 @@ func (h *WSServerRedirector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "ws upgrade error", http.StatusInternalServerError)
 		return
 	}
-	h.register <- newClient(conn, h.heartbeat, func(messageType int, message []byte) {
-		if h.onMessage != nil {
-			_ = h.onMessage(message, "WS_SERVER", currentUser)
+	client := &wsClient{
+		streamClient: &streamClient{
+			info: &ClientInfo{
+				ID:          r.RemoteAddr,
+				Name:        r.URL.Query().Get("client"),
+				User:        currentUser,
+				ConnectTime: time.Now().UnixMilli(),
+			},
+			identity: identity,
+			queue:    newClientQueue(h.queueSize, h.overflow, h.spillDir, h.spillLimit),
+		},
+		name: r.URL.Query().Get("client"),
+	}
+	client.wsConnection = newClient(conn, h.heartbeat, func(messageType int, message []byte) {
+		// 订阅及续传命令由连接自己处理,其余命令交给hub
+		reply, ok := h.handle(client, message)
+		if !ok && h.onMessage != nil {
+			reply, _ = h.onMessage(message, "WS_SERVER", identity)
+		}
+		// 回复到同一连接
+		if reply != nil {
+			if err := client.push(reply); err != nil {
+				slog.Error("回复消息失败", "user", currentUser, "err", err)
+			}
 		}
 	})
-}
-
-func (h *WSServerRedirector) SendMessage(bytes []byte) error {
-	h.messages <- bytes
-	return nil
+	// 入队失败时断开连接
+	client.disconnect = func(error) {
+		client.Close()
+	}
+	h.add(client.streamClient)
+	go client.pump(h.ctx)
+	go func() {
+		_ = client.Serve(h.ctx)
+		// 连接断开后移除
+		h.remove(client.streamClient)
+		client.Close()
+	}()
 }
 
 func (h *WSServerRedirector) OnMessage(fn OnMessage) {
@@ -9,8 +9,10 @@ import (
 	"io"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 	"wechat-hub/hub"
+	"wechat-hub/pkg/lru"
 	"wechat-hub/storage"
 
 	"github.com/eatmoreapple/openwechat"
@@ -24,6 +26,14 @@ type MsgSender struct {
 	Resty   *resty.Client
 	limit   *rate.Limiter
 	storage storage.Storage
+	sentMu  sync.Mutex
+	sent    *lru.LRU[string, *sentMessage] // 已发送消息,用于撤回
+	audit   hub.AuditManager
+}
+
+type sentMessage struct {
+	*openwechat.SentMessage
+	gid string // 发送到的群id,私聊为空
 }
 type SenderOption = func(sender *MsgSender)
 
@@ -32,12 +42,21 @@ func WithLimit(r rate.Limit, b int) SenderOption {
 		sender.limit = rate.NewLimiter(r, b)
 	}
 }
+
+// WithAudit 记录所有发送及撤回操作
+func WithAudit(audit hub.AuditManager) SenderOption {
+	return func(sender *MsgSender) {
+		sender.audit = audit
+	}
+}
+
 func NewMsgSender(bot *openwechat.Bot, member hub.MemberManager, storage storage.Storage, options ...SenderOption) *MsgSender {
 	sender := &MsgSender{
 		member:  member,
 		Bot:     bot,
 		Resty:   resty.New(),
 		storage: storage,
+		sent:    lru.New[string, *sentMessage](200),
 	}
 	for _, option := range options {
 		option(sender)
@@ -55,65 +74,179 @@ func (s *MsgSender) getSelf() (*openwechat.Self, error) {
 	return s.Bot.GetCurrentUser()
 }
 
-func (s *MsgSender) SendMsg(msg *hub.SendMsgCommand) error {
-	if msg.Gid == "" {
-		return errors.New("群ID不能为空")
+// remember 记录已发送的消息及发送到的群,返回消息id
+func (s *MsgSender) remember(sent *openwechat.SentMessage, gid string) string {
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	s.sent.Put(sent.MsgId, &sentMessage{SentMessage: sent, gid: gid})
+	return sent.MsgId
+}
+
+// SentGroup 获取已发送消息的群id,私聊消息及不知道群id的消息为空
+func (s *MsgSender) SentGroup(msgID string) (string, bool) {
+	s.sentMu.Lock()
+	defer s.sentMu.Unlock()
+	sent, ok := s.sent.Get(msgID)
+	if !ok {
+		return "", false
+	}
+	return sent.gid, true
+}
+
+// Revoke 撤回已发送的消息,只能撤回2分钟内发送的消息
+func (s *MsgSender) Revoke(msgID string, actor hub.Actor) error {
+	gid, _ := s.SentGroup(msgID)
+	err := s.revoke(msgID)
+	s.record(&hub.Audit{
+		Action: hub.AuditRevoke,
+		Gid:    gid,
+		MsgID:  msgID,
+	}, actor, err)
+	return err
+}
+
+func (s *MsgSender) revoke(msgID string) error {
+	s.sentMu.Lock()
+	sent, ok := s.sent.Get(msgID)
+	s.sentMu.Unlock()
+	if !ok {
+		return errors.New("消息不存在或已过期")
 	}
-	if msg.Body == "" {
-		return errors.New("消息不能为空")
+	if !sent.CanRevoke() {
+		return errors.New("消息已超过撤回时间")
+	}
+	return sent.Revoke()
+}
+
+// SendMsg 发送消息,返回发送成功的消息id
+func (s *MsgSender) SendMsg(msg *hub.SendMsgCommand, actor hub.Actor) (string, error) {
+	msgID, err := s.sendMsg(msg)
+	s.record(&hub.Audit{
+		Action:  hub.AuditSend,
+		Gid:     msg.Gid,
+		Uid:     msg.Uid,
+		MsgType: msg.Type,
+		Summary: msg.Summary(),
+		MsgID:   msgID,
+	}, actor, err)
+	return msgID, err
+}
+
+// record 记录操作审计,记录失败不影响操作结果
+func (s *MsgSender) record(audit *hub.Audit, actor hub.Actor, err error) {
+	if s.audit == nil {
+		return
+	}
+	audit.Username = actor.Username
+	audit.TokenID = actor.TokenID
+	audit.Channel = actor.Channel
+	audit.Redirector = actor.Redirector
+	audit.Success = err == nil
+	if err != nil {
+		audit.Error = err.Error()
+	}
+	if err := s.audit.Record(audit); err != nil {
+		slog.Error("记录操作审计失败", "action", audit.Action, "user", actor.Username, "channel", actor.Channel, "redirector", actor.Redirector, "err", err)
+	}
+}
+
+func (s *MsgSender) sendMsg(msg *hub.SendMsgCommand) (string, error) {
+	if err := msg.Validate(); err != nil {
+		return "", err
+	}
+	if msg.Uid != "" {
+		switch msg.Type {
+		case 1:
+			return s.SendFriendTextMsgByID(msg.Uid, msg.Body)
+		default:
+			return s.SendFriendMediaMsgByID(msg.Uid, msg.Type, msg.Body, msg.Filename, msg.Prompt)
+		}
 	}
 	switch msg.Type {
 	case 1:
-		if _, err := s.SendGroupTextMsgByID(msg.Gid, msg.Body); err != nil {
-			return err
-		}
+		return s.SendGroupTextMsgByID(msg.Gid, msg.Body)
 	case 2, 3, 4:
-		if _, err := s.SendGroupMediaMsgByID(msg.Gid, msg.Type, msg.Body, msg.Filename, msg.Prompt); err != nil {
-			return err
-		}
+		return s.SendGroupMediaMsgByID(msg.Gid, msg.Type, msg.Body, msg.Filename, msg.Prompt)
 	default:
-		return errors.New("暂不支持该类型消息")
+		return "", errors.New("暂不支持该类型消息")
 	}
-	return nil
 }
 
-func (s *MsgSender) SendGroupTextMsgByID(id string, msg string) (string, error) {
+// chatTarget 消息接收方,群或好友
+type chatTarget interface {
+	SendText(content string) (*openwechat.SentMessage, error)
+	SendImage(file io.Reader) (*openwechat.SentMessage, error)
+	SendVideo(file io.Reader) (*openwechat.SentMessage, error)
+	SendFile(file io.Reader) (*openwechat.SentMessage, error)
+}
+
+// getGroupByID 根据群id查找群
+func (s *MsgSender) getGroupByID(id string) (*openwechat.Group, error) {
 	self, err := s.getSelf()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	gid, err := s.member.GetByID(id)
 	if err != nil {
-		return "", err
+		return nil, err
 	} else if gid == "" {
-		return "", errors.New("群不存在")
+		return nil, errors.New("群不存在")
 	}
-
 	groups, _ := self.Groups()
 	group := groups.SearchByUserName(1, gid).First()
 	if group == nil {
-		return "", errors.New("群不存在")
+		return nil, errors.New("群不存在")
 	}
+	return group, nil
+}
 
-	// 限流最大等待
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	_ = s.limit.Wait(ctx) // 忽略限流，只是为了人为等待
-	cancel()
+// getFriendByID 根据用户id查找好友
+func (s *MsgSender) getFriendByID(id string) (*openwechat.Friend, error) {
+	self, err := s.getSelf()
+	if err != nil {
+		return nil, err
+	}
+	uid, err := s.member.GetByID(id)
+	if err != nil {
+		return nil, err
+	} else if uid == "" {
+		return nil, errors.New("好友不存在")
+	}
+	friends, _ := self.Friends()
+	friend := friends.SearchByUserName(1, uid).First()
+	if friend == nil {
+		return nil, errors.New("好友不存在")
+	}
+	return friend, nil
+}
 
-	if sent, err := self.SendTextToGroup(group, msg); err != nil {
+func (s *MsgSender) SendGroupTextMsgByID(id string, msg string) (string, error) {
+	group, err := s.getGroupByID(id)
+	if err != nil {
 		return "", err
-	} else {
-		return sent.MsgId, nil
 	}
+	return s.sendTextMsg(group, id, msg)
 }
 
 func (s *MsgSender) SendGroupTextMsg(group *openwechat.Group, msg string) (string, error) {
-	self, err := s.getSelf()
+	if group == nil {
+		return "", errors.New("群不存在")
+	}
+	return s.sendTextMsg(group, "", msg)
+}
+
+func (s *MsgSender) SendFriendTextMsgByID(id string, msg string) (string, error) {
+	friend, err := s.getFriendByID(id)
 	if err != nil {
 		return "", err
 	}
-	if group == nil {
-		return "", errors.New("群不存在")
+	return s.sendTextMsg(friend, "", msg)
+}
+
+// sendTextMsg 发送文本消息,gid为发送到的群id,用于撤回时校验群授权
+func (s *MsgSender) sendTextMsg(target chatTarget, gid string, msg string) (string, error) {
+	if _, err := s.getSelf(); err != nil {
+		return "", err
 	}
 
 	// 限流最大等待
@@ -121,111 +254,87 @@ func (s *MsgSender) SendGroupTextMsg(group *openwechat.Group, msg string) (strin
 	_ = s.limit.Wait(ctx) // 忽略限流，只是为了人为等待
 	cancel()
 
-	if sent, err := self.SendTextToGroup(group, msg); err != nil {
+	if sent, err := target.SendText(msg); err != nil {
 		return "", err
 	} else {
-		return sent.MsgId, nil
+		return s.remember(sent, gid), nil
 	}
 }
 
 func (s *MsgSender) SendGroupMediaMsgByID(id string, mediaType int, src string, filename string, prompt string) (string, error) {
-	self, err := s.getSelf()
+	group, err := s.getGroupByID(id)
 	if err != nil {
 		return "", err
 	}
-	gid, err := s.member.GetByID(id)
+	return s.sendMediaMsg(group, id, mediaType, src, filename, prompt)
+}
+
+func (s *MsgSender) SendGroupMediaMsg(group *openwechat.Group, mediaType int, src string, filename string, prompt string) (string, error) {
+	return s.sendMediaMsg(group, "", mediaType, src, filename, prompt)
+}
+
+func (s *MsgSender) SendFriendMediaMsgByID(id string, mediaType int, src string, filename string, prompt string) (string, error) {
+	friend, err := s.getFriendByID(id)
 	if err != nil {
 		return "", err
-	} else if gid == "" {
-		return "", errors.New("群不存在")
 	}
-	groups, _ := self.Groups()
-	group := groups.SearchByUserName(1, gid).First()
-	if group == nil {
-		return "", errors.New("群不存在")
-	}
-	return s.SendGroupMediaMsg(group, mediaType, src, filename, prompt)
+	return s.sendMediaMsg(friend, "", mediaType, src, filename, prompt)
 }
 
-func (s *MsgSender) SendGroupMediaMsg(group *openwechat.Group, mediaType int, src string, filename string, prompt string) (string, error) {
-	self, err := s.getSelf()
-	if err != nil {
+func (s *MsgSender) sendMediaMsg(target chatTarget, gid string, mediaType int, src string, filename string, prompt string) (string, error) {
+	if _, err := s.getSelf(); err != nil {
 		return "", err
 	}
 
+	var send func(file io.Reader) (*openwechat.SentMessage, error)
 	switch mediaType {
 	case 2:
 		if filename == "" {
 			filename = fmt.Sprintf("%x.jpg", md5.Sum([]byte(src)))
 		}
-		reader, promptSent, err := s.prepareFile(self, group, src, filename, prompt)
-		if err != nil {
-			return "", err
-		}
-		defer func() {
-			if promptSent != nil {
-				_ = promptSent.Revoke()
-			}
-			_ = reader.Close()
-		}()
-		if sent, err := self.SendImageToGroup(group, reader); err != nil {
-			return "", err
-		} else {
-			return sent.MsgId, nil
-		}
+		send = target.SendImage
 	case 3:
 		if filename == "" {
 			filename = fmt.Sprintf("%x.mp4", md5.Sum([]byte(src)))
 		}
-		reader, promptSent, err := s.prepareFile(self, group, src, filename, prompt)
-		if err != nil {
-			return "", err
-		}
-		defer func() {
-			if promptSent != nil {
-				_ = promptSent.Revoke()
-			}
-			_ = reader.Close()
-		}()
-		if sent, err := self.SendVideoToGroup(group, reader); err != nil {
-			return "", err
-		} else {
-			return sent.MsgId, nil
-		}
+		send = target.SendVideo
 	case 4:
 		if filename == "" {
 			filename = fmt.Sprintf("%x", md5.Sum([]byte(src)))
 		}
-		reader, promptSent, err := s.prepareFile(self, group, src, filename, prompt)
-		if err != nil {
-			return "", err
-		}
-		defer func() {
-			if promptSent != nil {
-				_ = promptSent.Revoke()
-			}
-			_ = reader.Close()
-		}()
-		if sent, err := self.SendFileToGroup(group, reader); err != nil {
-			return "", err
-		} else {
-			return sent.MsgId, nil
-		}
+		send = target.SendFile
 	default:
 		return "", errors.New("暂不支持该类型")
 	}
 
+	reader, promptSent, err := s.prepareFile(target, src, filename, prompt)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if promptSent != nil {
+			_ = promptSent.Revoke()
+		}
+		_ = reader.Close()
+	}()
+	if sent, err := send(reader); err != nil {
+		return "", err
+	} else {
+		return s.remember(sent, gid), nil
+	}
 }
 
-func (s *MsgSender) prepareFile(self *openwechat.Self, group *openwechat.Group, src string, filename string, prompt string) (reader io.ReadCloser, promptSent *openwechat.SentMessage, err error) {
+func (s *MsgSender) prepareFile(target chatTarget, src string, filename string, prompt string) (reader io.ReadCloser, promptSent *openwechat.SentMessage, err error) {
 	if prompt != "" {
 		// 限流等待
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 		_ = s.limit.Wait(ctx) // 忽略限流，只是为了人为等待
 		cancel()
-		promptSent, _ = self.SendTextToGroup(group, prompt)
+		promptSent, _ = target.SendText(prompt)
 		defer func() {
-			_ = promptSent.Revoke()
+			if promptSent != nil {
+				_ = promptSent.Revoke()
+			}
 		}()
 	}
 	// 加载资源